@@ -4,9 +4,17 @@ set GOPATH=%CD%
 go build github.com/jzipfler/HTW-SwArchitektur/service
 go install github.com/jzipfler/HTW-SwArchitektur/randomservice
 go install github.com/jzipfler/HTW-SwArchitektur/isprimeservice
+go install github.com/jzipfler/HTW-SwArchitektur/primesservice
+go install github.com/jzipfler/HTW-SwArchitektur/calculatorservice
+go install github.com/jzipfler/HTW-SwArchitektur/factorizeservice
 go install github.com/jzipfler/HTW-SwArchitektur/concatenateservice
 go install github.com/jzipfler/HTW-SwArchitektur/serviceuser
 go install github.com/jzipfler/HTW-SwArchitektur/registryserver
 go build github.com/jzipfler/HTW-SwArchitektur/signalhandler
 go install github.com/jzipfler/HTW-SwArchitektur/menu
+go install github.com/jzipfler/HTW-SwArchitektur/contractdiff
+go install github.com/jzipfler/HTW-SwArchitektur/gateway
+go install github.com/jzipfler/HTW-SwArchitektur/contractdoc
+go install github.com/jzipfler/HTW-SwArchitektur/stubgen
+go install github.com/jzipfler/HTW-SwArchitektur/scaffold
 echo done
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/jzipfler/HTW-SwArchitektur/service"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var serviceCalculator = service.ServiceInfo{
+	Name:        "calculator",
+	Version:     "1.0.0",
+	ResultType:  "float",
+	Description: "Interactive calculator: applies operations like \"+ 2\" or \"* 3\" to the value and answers each with the new value.",
+	Arguments: []service.ArgumentInfo{
+		{Name: "value", Type: "float", Description: "initial value"},
+	},
+	Interactive: true,
+}
+
+// Applies an operation ("+ 2", "- 2", "* 2" or "/ 2") to the value.
+func apply(value float64, operation string) (float64, error) {
+	fields := strings.Fields(operation)
+	if len(fields) != 2 {
+		return value, fmt.Errorf("invalid operation %q", operation)
+	}
+	operand, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return value, err
+	}
+	switch fields[0] {
+	case "+":
+		return value + operand, nil
+	case "-":
+		return value - operand, nil
+	case "*":
+		return value * operand, nil
+	case "/":
+		return value / operand, nil
+	}
+	return value, fmt.Errorf("invalid operator %q", fields[0])
+}
+
+// Main function of the "calculator" service
+func calculatorHandler(ctx context.Context, servicecall *service.ServiceCall, session *service.Session) error {
+	value := 0.0
+	if len(servicecall.Arguments) > 0 {
+		value, _ = strconv.ParseFloat(servicecall.Arguments[0], 64)
+	}
+
+	for {
+		operation, err := session.Receive()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		reply := ""
+		result, err := apply(value, operation)
+		if err != nil {
+			reply = "error: " + err.Error()
+		} else {
+			value = result
+			reply = strconv.FormatFloat(value, 'g', -1, 64)
+		}
+		fmt.Println("calculator:", operation, "=", reply)
+		err = session.Send(reply)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func main() {
+	// register "calculator" as interactive service
+	fmt.Println("running...")
+	err := service.RunSessionService(&serviceCalculator, calculatorHandler)
+	if err != nil {
+		fmt.Println("Error occured: ")
+		fmt.Println(err)
+	}
+}
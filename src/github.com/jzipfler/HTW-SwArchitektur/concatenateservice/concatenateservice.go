@@ -6,13 +6,14 @@ import (
 )
 
 var serviceConcatenate = service.ServiceInfo{
-	"concatenate",
-	"var",
-	"Concatenate tow services service1(service2()) as service.",
-	[]service.ArgumentInfo{
-		{"service1", "string", "first service name"},
-		{"service2", "string", "second service name"},
-		{"service", "string", "new service name"},
+	Name:        "concatenate",
+	Version:     "1.0.0",
+	ResultType:  "var",
+	Description: "Concatenate tow services service1(service2()) as service.",
+	Arguments: []service.ArgumentInfo{
+		{Name: "service1", Type: "string", Description: "first service name"},
+		{Name: "service2", Type: "string", Description: "second service name"},
+		{Name: "service", Type: "string", Description: "new service name"},
 	},
 }
 
@@ -20,11 +21,12 @@ var serviceConcatenate = service.ServiceInfo{
 func createCompositeService(service1, service2, servicenew string) {
 	desc := service1 + "(" + service2 + "())."
 	serviceInfo := service.ServiceInfo{
-		servicenew,
-		"string",
-		desc,
-		[]service.ArgumentInfo{
-			{"void", "void", "void"},
+		Name:        servicenew,
+		Version:     "1.0.0",
+		ResultType:  "string",
+		Description: desc,
+		Arguments: []service.ArgumentInfo{
+			{Name: "void", Type: "void", Description: "void"},
 		},
 	}
 	
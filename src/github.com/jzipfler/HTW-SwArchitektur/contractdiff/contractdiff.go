@@ -0,0 +1,45 @@
+// Command contractdiff compares two service contracts and reports
+// breaking and compatible changes between them. Each contract is
+// either a JSON or YAML contract file (see service.LoadContract()) or the
+// name of a registered service with an optional version constraint, e.g.
+//
+//	contractdiff isprime@1.0 isprime@^2
+//	contractdiff isprime-v1.json isprime-v2.json
+//
+// The exit code is 1 if there are breaking changes.
+package main
+
+import (
+	"fmt"
+	"github.com/jzipfler/HTW-SwArchitektur/service"
+	"os"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Println("usage: contractdiff <old contract> <new contract>")
+		os.Exit(2)
+	}
+
+	older, err := service.LoadContractSource(os.Args[1])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	newer, err := service.LoadContractSource(os.Args[2])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	changes := service.CompareContracts(older, newer)
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if len(changes) == 0 {
+		fmt.Println("contracts are identical")
+	}
+	if service.HasBreakingChanges(changes) {
+		os.Exit(1)
+	}
+}
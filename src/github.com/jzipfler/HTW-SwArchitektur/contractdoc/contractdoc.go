@@ -0,0 +1,71 @@
+// Command contractdoc exports service contracts as OpenAPI 3 document
+// (describing the paths of the gateway) or as JSON Schemas. Contracts are
+// JSON or YAML contract files (see service.LoadContract()) or names of
+// registered services with an optional version constraint. Without
+// contracts, all registered services are exported, e.g.
+//
+//	contractdoc -server http://localhost:8081 > openapi.json
+//	contractdoc -schema isprime@^1
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jzipfler/HTW-SwArchitektur/service"
+	"os"
+)
+
+// Loads the given contracts, or the contracts of all registered services.
+func loadContracts(sources []string) (map[string]service.ServiceInfo, error) {
+	contracts := make(map[string]service.ServiceInfo)
+	if len(sources) == 0 {
+		list, err := service.GetServiceList()
+		if err != nil {
+			return nil, err
+		}
+		for name, instance := range *list {
+			contracts[name] = instance.Info
+		}
+		return contracts, nil
+	}
+
+	for _, source := range sources {
+		info, err := service.LoadContractSource(source)
+		if err != nil {
+			return nil, err
+		}
+		contracts[info.Name] = *info
+	}
+	return contracts, nil
+}
+
+func main() {
+	server := flag.String("server", "", "URL of the gateway in the OpenAPI document")
+	schema := flag.Bool("schema", false, "export JSON Schemas instead of an OpenAPI document")
+	flag.Parse()
+
+	contracts, err := loadContracts(flag.Args())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var document interface{} = service.OpenAPIDocument(contracts, *server)
+	if *schema {
+		schemas := make(map[string]interface{})
+		for name, info := range contracts {
+			info := info
+			schemas[name] = service.ContractJSONSchema(&info)
+		}
+		document = schemas
+		if len(flag.Args()) == 1 {
+			for _, single := range schemas {
+				document = single
+			}
+		}
+	}
+
+	bytes, _ := json.MarshalIndent(document, "", "  ")
+	fmt.Println(string(bytes))
+}
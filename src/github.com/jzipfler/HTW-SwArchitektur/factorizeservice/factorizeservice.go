@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jzipfler/HTW-SwArchitektur/service"
+	"math/big"
+	"strings"
+)
+
+var serviceFactorize = service.ServiceInfo{
+	Name:        "factorize",
+	Version:     "1.0.0",
+	ResultType:  "string",
+	Description: "Factorizes x by trial division, which may take very long; call it as job.",
+	Arguments: []service.ArgumentInfo{
+		{Name: "x", Type: "string", Description: "number to factorize (any size)"},
+	},
+	Idempotent: true,
+}
+
+// Number of divisions between checks whether the job was canceled.
+const CHECK_INTERVAL = 100000
+
+// Main function of the "factorize" service
+func factorizeHandler(servicecall *service.ServiceCall) string {
+	if len(servicecall.Arguments) != 1 {
+		return "error: expected 1 argument"
+	}
+	number, ok := new(big.Int).SetString(servicecall.Arguments[0], 10)
+	if !ok || number.Sign() <= 0 {
+		return "error: invalid number " + servicecall.Arguments[0]
+	}
+
+	ctx := servicecall.Context()
+	factors := []string{}
+	divisor, step := big.NewInt(2), big.NewInt(1)
+	quotient, remainder := new(big.Int), new(big.Int)
+	checkPrime := true
+	for i := 1; number.Cmp(big.NewInt(1)) > 0; i++ {
+		if checkPrime && number.ProbablyPrime(16) {
+			factors = append(factors, number.String())
+			break
+		}
+		if i%CHECK_INTERVAL == 0 && ctx.Err() != nil {
+			fmt.Println("factorize canceled:", servicecall.Arguments[0])
+			return "error: canceled"
+		}
+
+		quotient.QuoRem(number, divisor, remainder)
+		checkPrime = remainder.Sign() == 0
+		if checkPrime {
+			factors = append(factors, divisor.String())
+			number.Set(quotient)
+			continue
+		}
+		divisor.Add(divisor, step)
+		step.SetInt64(2)
+	}
+
+	result := strings.Join(factors, " * ")
+	fmt.Println("factorize():", servicecall.Arguments[0], "=", result)
+	return result
+}
+
+func main() {
+	// register "factorize" as service
+	fmt.Println("running...")
+	err := service.RunService(&serviceFactorize, factorizeHandler)
+	if err != nil {
+		fmt.Println("Error occured: ")
+		fmt.Println(err)
+	}
+}
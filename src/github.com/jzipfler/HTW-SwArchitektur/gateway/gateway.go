@@ -0,0 +1,157 @@
+// Command gateway exposes all registered services as REST endpoints, so
+// they can be called without implementing the service protocol:
+//
+//	POST /services/{name}[?version=^1.2]  {"x": 7}  ->  {"Result": "..."}
+//	GET  /services                                  ->  all routes with their contracts
+//
+// The JSON object of a call maps the argument names of the contract
+// (ArgumentInfo.Name) to their values. The routes are updated periodically
+// from the service list of the registry.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jzipfler/HTW-SwArchitektur/service"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	port    = flag.Int("port", 8081, "TCP port of the gateway")
+	refresh = flag.Duration("refresh", 5*time.Second, "interval in which the routes are updated")
+	// Routes of the gateway, mapping from service name to contract.
+	routes     = make(map[string]service.ServiceInfo)
+	routesLock sync.Mutex
+)
+
+// Periodically updates the routes from the service list of the registry.
+func updateRoutes() {
+	for {
+		list, err := service.GetServiceList()
+		if err != nil {
+			fmt.Println("error: GetServiceList():", err)
+		} else {
+			routesLock.Lock()
+			for name := range routes {
+				if _, ok := (*list)[name]; !ok {
+					fmt.Println("route removed: /services/" + name)
+					delete(routes, name)
+				}
+			}
+			for name, serviceInfoAddress := range *list {
+				if _, ok := routes[name]; !ok {
+					fmt.Println("route added: /services/" + name)
+				}
+				routes[name] = serviceInfoAddress.Info
+			}
+			routesLock.Unlock()
+		}
+		time.Sleep(*refresh)
+	}
+}
+
+// Writes the value as JSON response with the given status code.
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(value)
+}
+
+// GET /services lists all routes, POST /services/{name} calls a service.
+func handleServices(writer http.ResponseWriter, request *http.Request) {
+	name := strings.TrimPrefix(request.URL.Path, "/services/")
+	if request.URL.Path == "/services" || request.URL.Path == "/services/" {
+		if request.Method != "GET" {
+			writeJSON(writer, http.StatusMethodNotAllowed, map[string]string{"Error": "method not allowed"})
+			return
+		}
+		routesLock.Lock()
+		defer routesLock.Unlock()
+		writeJSON(writer, http.StatusOK, routes)
+		return
+	}
+	if request.Method != "POST" {
+		writeJSON(writer, http.StatusMethodNotAllowed, map[string]string{"Error": "method not allowed"})
+		return
+	}
+
+	name = service.CanonicalServiceName(name)
+	version := request.URL.Query().Get("version")
+	routesLock.Lock()
+	info, ok := routes[name]
+	routesLock.Unlock()
+	if !ok {
+		writeJSON(writer, http.StatusNotFound, map[string]string{"Error": "service not found"})
+		return
+	}
+	if version != "" {
+		// the arguments of the requested version may differ from the route
+		err := service.ValidateConstraint(version)
+		if err != nil {
+			writeJSON(writer, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+			return
+		}
+		instance, err := service.GetServiceInfoVersion(name, version)
+		if err != nil {
+			writeJSON(writer, http.StatusBadGateway, map[string]string{"Error": err.Error()})
+			return
+		}
+		if instance.Address == "" {
+			writeJSON(writer, http.StatusNotFound, map[string]string{"Error": "no version " + version + " of the service"})
+			return
+		}
+		info = instance.Info
+	}
+
+	named := make(map[string]interface{})
+	if request.ContentLength != 0 {
+		decoder := json.NewDecoder(request.Body)
+		decoder.UseNumber()
+		err := decoder.Decode(&named)
+		if err != nil {
+			writeJSON(writer, http.StatusBadRequest, map[string]string{"Error": "invalid JSON: " + err.Error()})
+			return
+		}
+	}
+	args, err := service.NamedArguments(&info, named)
+	if err != nil {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"Error": err.Error()})
+		return
+	}
+
+	result, err := service.CallServiceVersion(name, version, args...)
+	if err != nil {
+		status := http.StatusBadGateway
+		serviceError, _ := err.(*service.ServiceError)
+		switch {
+		case service.ClassifyError(err) == service.ERROR_OVERLOADED || err == service.ErrCircuitOpen:
+			status = http.StatusServiceUnavailable
+		case serviceError != nil && serviceError.Code == service.SERVICE_INTERNAL:
+			status = http.StatusInternalServerError
+		case serviceError != nil && serviceError.Code == service.SERVICE_RATE_LIMITED:
+			status = http.StatusTooManyRequests
+		}
+		writeJSON(writer, status, map[string]string{"Error": err.Error()})
+		return
+	}
+	writeJSON(writer, http.StatusOK, service.ServiceResult{Result: result})
+}
+
+func main() {
+	flag.Parse()
+	go updateRoutes()
+
+	fmt.Println("running...")
+	http.HandleFunc("/services", handleServices)
+	http.HandleFunc("/services/", handleServices)
+	err := http.ListenAndServe(":"+strconv.Itoa(*port), nil)
+	if err != nil {
+		fmt.Println("Error occured: ")
+		fmt.Println(err)
+	}
+}
@@ -8,12 +8,14 @@ import (
 )
 
 var serviceIsPrime = service.ServiceInfo{
-	"isprime",
-	"string",
-	"Performs 16 Miller-Rabin tests to check whether x is prime.",
-	[]service.ArgumentInfo{
-		{"x", "int", "number to test"},
+	Name:        "isprime",
+	Version:     "1.0.0",
+	ResultType:  "string",
+	Description: "Performs 16 Miller-Rabin tests to check whether x is prime.",
+	Arguments: []service.ArgumentInfo{
+		{Name: "x", Type: "int", Description: "number to test"},
 	},
+	Idempotent: true,
 }
 
 // Main function of the "isprime" service
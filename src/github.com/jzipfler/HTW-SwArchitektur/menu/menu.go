@@ -7,6 +7,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/jzipfler/HTW-SwArchitektur/service"
 	"github.com/jzipfler/HTW-SwArchitektur/signalhandler"
@@ -165,6 +166,10 @@ func aufrufenService() {
 			fmt.Scan(&parameter[i])
 			fmt.Println(ZEILENUMBRUCH)
 		}
+		if serviceInformation.Info.Streaming {
+			streamAusgeben(serviceName, parameter)
+			return
+		}
 		switch len(serviceInformation.Info.Arguments) {
 		case 1:
 			serviceAusgabe, err = service.CallService(serviceName, parameter[0])
@@ -176,6 +181,9 @@ func aufrufenService() {
 			informationenAusgeben("Unbekannte Anzahl Parameter", true)
 			return
 		}
+	} else if serviceInformation.Info.Streaming {
+		streamAusgeben(serviceName, nil)
+		return
 	} else {
 		serviceAusgabe, err = service.CallService(serviceName)
 	}
@@ -198,6 +206,31 @@ func informationenAusgeben(infos string, fehlerAusgabe bool) {
 	fmt.Println(FOOTER + ZEILENUMBRUCH)
 }
 
+// Ruft einen Streaming-Service auf und gibt dessen Ergebnisse
+// aus, sobald sie eintreffen. Der Service sendet weitere
+// Ergebnisse erst, wenn die bisherigen ausgegeben wurden.
+func streamAusgeben(serviceName string, parameter []string) {
+	stream, err := service.CallServiceStream(context.Background(), serviceName, "", parameter...)
+	if err != nil {
+		informationenAusgeben(err.Error(), true)
+		return
+	}
+	defer stream.Close()
+
+	fmt.Println(AUSGABE_HEADER)
+	anzahl := 0
+	for ergebnis := range stream.Results() {
+		anzahl++
+		fmt.Printf("%d.\t%s\n", anzahl, ergebnis)
+	}
+	if stream.Err() != nil {
+		fmt.Println(FEHLER_HEADER)
+		fmt.Println(stream.Err())
+	}
+	fmt.Printf("%d Ergebnisse empfangen.\n", anzahl)
+	fmt.Println(FOOTER + ZEILENUMBRUCH)
+}
+
 // Diese Methode verarbeitet die übergebene ServiceInfoAddress
 // und gibt einen String zurück indem diese Datenstruktur
 // formatiert und mit Bezeichnern abgelegt ist.
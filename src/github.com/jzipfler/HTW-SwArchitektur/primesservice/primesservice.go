@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jzipfler/HTW-SwArchitektur/service"
+	"math/big"
+	"strconv"
+)
+
+var servicePrimes = service.ServiceInfo{
+	Name:        "primes",
+	Version:     "1.0.0",
+	ResultType:  "int",
+	Description: "Streams all primes between from and to.",
+	Arguments: []service.ArgumentInfo{
+		{Name: "from", Type: "int", Description: "lower bound"},
+		{Name: "to", Type: "int", Description: "upper bound"},
+	},
+	Idempotent: true,
+	Streaming:  true,
+}
+
+// Main function of the "primes" service
+func primesHandler(ctx context.Context, servicecall *service.ServiceCall, send service.StreamSender) error {
+	if len(servicecall.Arguments) != 2 {
+		return errors.New("expected 2 arguments")
+	}
+	from, err := strconv.ParseInt(servicecall.Arguments[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	to, err := strconv.ParseInt(servicecall.Arguments[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("primes(%d, %d)\n", from, to)
+	for number := from; number <= to && number >= from; number++ {
+		if !big.NewInt(number).ProbablyPrime(16) {
+			continue
+		}
+		err = send(strconv.FormatInt(number, 10))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	// register "primes" as streaming service
+	fmt.Println("running...")
+	err := service.RunStreamService(&servicePrimes, primesHandler)
+	if err != nil {
+		fmt.Println("Error occured: ")
+		fmt.Println(err)
+	}
+}
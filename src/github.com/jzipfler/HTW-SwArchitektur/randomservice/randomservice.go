@@ -9,11 +9,12 @@ import (
 )
 
 var serviceRandom = service.ServiceInfo{
-	"random",
-	"int",
-	"Generates a random int",
-	[]service.ArgumentInfo{
-		{"void", "void", "no arguments"},
+	Name:        "random",
+	Version:     "1.0.0",
+	ResultType:  "int",
+	Description: "Generates a random int",
+	Arguments: []service.ArgumentInfo{
+		{Name: "void", Type: "void", Description: "no arguments"},
 	},
 }
 
@@ -1,12 +1,76 @@
 package main
 
 import (
-	"github.com/jzipfler/HTW-SwArchitektur/service"
+	"flag"
 	"fmt"
+	"github.com/jzipfler/HTW-SwArchitektur/service"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	quota  = flag.Int("quota", 0, "maximum number of services per namespace (0 = unlimited)")
+	quotas = flag.String("quotas", "", "quotas of specific namespaces, e.g. \"team-a=5,team-b=10\"")
+	port   = flag.Int("port", 0, "TCP port of the registry (0 = any free port)")
+	peers  = flag.String("peers", "", "peer registries to federate with, e.g. \"10.0.1.5:32002,10.0.2.5:32002\"")
+	proxy  = flag.Bool("proxy", false, "answer lookups for remote services with a local proxy address")
+	sync   = flag.Duration("sync", 10*time.Second, "interval in which the catalogs of the peers are fetched")
+	mdns   = flag.Bool("mdns", false, "publish all services via mDNS / DNS-SD as "+service.MDNS_SERVICE_TYPE)
+	http   = flag.Int("http", 0, "TCP port of the HTTP admin API (0 = disabled)")
+	host   = flag.String("http-host", "", "host the HTTP admin API listens on, e.g. 127.0.0.1 (default all interfaces)")
+	token  = flag.String("http-token", "", "token required to deregister and drain services via the HTTP admin API")
+	health = flag.Duration("health", 0, "interval of the health checks of all services (0 = disabled)")
+	rate   = flag.Float64("lookup-rate", 0, "maximum lookups per second of each client (0 = unlimited)")
+	burst  = flag.Int("lookup-burst", 10, "maximum burst of lookups of each client")
 )
 
+// Parses the quotas of specific namespaces ("team-a=5,team-b=10").
+func parseQuotas(value string) (map[string]int, error) {
+	result := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid quota %q", entry)
+		}
+		number, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota %q", entry)
+		}
+		result[parts[0]] = number
+	}
+	return result, nil
+}
+
 func main() {
+	flag.Parse()
+	namespaceQuotas, err := parseQuotas(*quotas)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	service.DEFAULT_NAMESPACE_QUOTA = *quota
+	service.NAMESPACE_QUOTAS = namespaceQuotas
+	service.REGISTRY_PORT = *port
+	service.REGISTRY_PROXY = *proxy
+	service.REGISTRY_SYNC_INTERVAL = *sync
+	service.REGISTRY_MDNS = *mdns
+	service.REGISTRY_HTTP_PORT = *http
+	service.REGISTRY_HTTP_HOST = *host
+	service.REGISTRY_HTTP_TOKEN = *token
+	service.REGISTRY_HEALTH_INTERVAL = *health
+	service.LOOKUP_RATE_LIMIT = service.RateLimit{Rate: *rate, Burst: *burst}
+	if *peers != "" {
+		service.REGISTRY_PEERS = strings.Split(*peers, ",")
+	}
+
 	// start registry server
 	fmt.Println("running...")
-	service.RunRegistryServer()
+	err = service.RunRegistryServer()
+	if err != nil {
+		fmt.Println(err)
+	}
 }
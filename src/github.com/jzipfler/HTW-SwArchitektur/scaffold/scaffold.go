@@ -0,0 +1,195 @@
+// Command scaffold generates the main package of a new service from a
+// contract file (JSON or YAML, see service.LoadContract). The package
+// contains a handler stub, a copy of the contract file, which is embedded
+// in the binary, and a main function which registers the service via
+// RunService (RunStreamService for streaming and RunSessionService for
+// interactive contracts), e.g.
+//
+//	scaffold -dir src/github.com/jzipfler/HTW-SwArchitektur/isprimeservice isprime.yaml
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/jzipfler/HTW-SwArchitektur/service"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Template of the generated main package.
+var mainTemplate = template.Must(template.New("main").Parse(`package main
+
+import (
+{{- if or .Info.Streaming .Info.Interactive}}
+	"context"
+{{- end}}
+	_ "embed"
+{{- if or .Info.Streaming .Info.Interactive}}
+	"errors"
+{{- end}}
+	"flag"
+	"fmt"
+	"github.com/jzipfler/HTW-SwArchitektur/service"
+	"os"
+)
+
+// Contract of the service, embedded from {{.ContractFile}}.
+//
+//go:embed {{.ContractFile}}
+var contractSource []byte
+
+// {{if .Info.Streaming}}Streaming handler{{else if .Info.Interactive}}Session handler{{else}}Main function{{end}} of the "{{.Info.Name}}" service: {{.Info.Description}}
+{{- if .Arguments}}
+//
+// Arguments:
+{{- range $i, $a := .Arguments}}
+//	servicecall.Arguments[{{$i}}]: {{$a.Name}} ({{$a.Type}}) {{$a.Description}}
+{{- end}}
+{{- end}}
+//
+// Result type: {{.Info.ResultType}}
+{{- if .Info.Streaming}}
+func {{.Handler}}(ctx context.Context, servicecall *service.ServiceCall, send service.StreamSender) error {
+	// TODO: implement the service, call send() for every result and stop
+	// when it returns an error
+	return errors.New("error: not implemented")
+}
+{{- else if .Info.Interactive}}
+func {{.Handler}}(ctx context.Context, servicecall *service.ServiceCall, session *service.Session) error {
+	// TODO: implement the service, exchange messages with session.Receive()
+	// and session.Send()
+	return errors.New("error: not implemented")
+}
+{{- else}}
+func {{.Handler}}(servicecall *service.ServiceCall) string {
+	// TODO: implement the service
+	return "error: not implemented"
+}
+{{- end}}
+
+func main() {
+	contractFile := flag.String("contract", "", "contract file of the service (default the embedded {{.ContractFile}})")
+	flag.Parse()
+
+	contract, err := service.ParseContract(contractSource, {{printf "%q" .ContractExtension}})
+	if *contractFile != "" {
+		contract, err = service.LoadContract(*contractFile)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// register "{{.Info.Name}}" as service
+	fmt.Println("running...")
+{{- if .Info.Streaming}}
+	err = service.RunStreamService(contract, {{.Handler}})
+{{- else if .Info.Interactive}}
+	err = service.RunSessionService(contract, {{.Handler}})
+{{- else}}
+	err = service.RunService(contract, {{.Handler}})
+{{- end}}
+	if err != nil {
+		fmt.Println("Error occured: ")
+		fmt.Println(err)
+	}
+}
+`))
+
+// Returns the name of the handler function of a service, e.g.
+// "isprimeHandler" for "isprime".
+func handlerName(name string) string {
+	_, local := service.SplitServiceName(name)
+	ident := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, local)
+	if ident == "" || unicode.IsDigit(rune(ident[0])) {
+		ident = "service" + ident
+	}
+	return ident + "Handler"
+}
+
+// Writes a file, unless it already exists.
+func writeNewFile(path string, data []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("error: %s already exists", path)
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func main() {
+	dir := flag.String("dir", "", "directory of the new package (default <name>service)")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Println("usage: scaffold [-dir directory] <contract file>")
+		os.Exit(2)
+	}
+
+	contractPath := flag.Arg(0)
+	info, err := service.LoadContract(contractPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *dir == "" {
+		_, local := service.SplitServiceName(info.Name)
+		*dir = local + "service"
+	}
+
+	if info.Streaming && info.Interactive {
+		fmt.Println("error: a service can't be both streaming and interactive")
+		os.Exit(1)
+	}
+
+	data := struct {
+		Info              *service.ServiceInfo
+		Arguments         []service.ArgumentInfo
+		Handler           string
+		ContractFile      string
+		ContractExtension string
+	}{info, nil, handlerName(info.Name), filepath.Base(contractPath), strings.ToLower(filepath.Ext(contractPath))}
+	for _, argument := range info.Arguments {
+		if argument.Type != "void" {
+			data.Arguments = append(data.Arguments, argument)
+		}
+	}
+
+	buffer := bytes.Buffer{}
+	err = mainTemplate.Execute(&buffer, data)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	source, err := format.Source(buffer.Bytes())
+	if err != nil {
+		fmt.Println("error: invalid generated code:", err)
+		os.Exit(1)
+	}
+	contract, err := ioutil.ReadFile(contractPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = os.MkdirAll(*dir, 0755)
+	if err == nil {
+		err = writeNewFile(filepath.Join(*dir, filepath.Base(*dir)+".go"), source)
+	}
+	if err == nil {
+		err = writeNewFile(filepath.Join(*dir, data.ContractFile), contract)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("created", *dir)
+}
@@ -0,0 +1,414 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// TCP port of the HTTP admin API of the registry (0 = disabled).
+	REGISTRY_HTTP_PORT = 0
+	// Host the HTTP admin API listens on, e.g. "127.0.0.1" to accept local
+	// requests only (empty = all interfaces).
+	REGISTRY_HTTP_HOST = ""
+	// Token which requests that change the registry (deregistering and
+	// draining) must send as "Authorization: Bearer <token>" (empty = no
+	// authentication).
+	REGISTRY_HTTP_TOKEN = ""
+	// Interval of the health checks of all registered instances (0 = no
+	// health checks).
+	REGISTRY_HEALTH_INTERVAL = time.Duration(0)
+	// Maximum number of health checks which run at the same time.
+	REGISTRY_HEALTH_WORKERS = 32
+	// Error of CheckServiceHealth() if the service doesn't answer
+	// HEALTH_CHECK_CALL, e.g. because it was built before health checks.
+	ErrHealthCheckUnsupported = errors.New("error: service doesn't support health checks")
+	// Health of the registered instances by address. Guarded by servicesLock.
+	instanceHealth = make(map[string]InstanceHealth)
+	// Times at which the health of instances was reset because they
+	// registered again, by address. Guarded by servicesLock.
+	healthReset = make(map[string]time.Time)
+	// Addresses of drained instances, which are no longer handed out by
+	// lookups but stay registered. Guarded by servicesLock.
+	drainedInstances = make(map[string]bool)
+)
+
+// Health of a registered instance, as determined by the periodic health
+// checks of the registry (see HEALTH_CHECK_CALL). The health of instances
+// which don't support health checks is unknown; they only have to accept
+// connections to be healthy.
+type InstanceHealth struct {
+	Healthy bool
+	Unknown bool `json:",omitempty"`
+	Checked time.Time
+	Error   string
+	Stats   ServiceStats
+}
+
+// State of a registered instance as shown by the admin API.
+type InstanceStatus struct {
+	ServiceInfoAddress
+	Health   InstanceHealth
+	Draining bool
+}
+
+// Returns true if lookups may hand out the instance, i.e. it's neither
+// drained nor did its last health check fail. The caller must hold
+// servicesLock.
+func instanceAvailable(instance ServiceInfoAddress) bool {
+	health, checked := instanceHealth[instance.Address]
+	return !drainedInstances[instance.Address] && (!checked || health.Healthy)
+}
+
+// Calls HEALTH_CHECK_CALL of the service at the given address and
+// returns its statistics. Returns ErrHealthCheckUnsupported if the service
+// answered, but not with its statistics.
+func CheckServiceHealth(address string) (ServiceStats, error) {
+	stats := ServiceStats{}
+	serviceresult := ServiceResult{}
+	buffer := make([]byte, PACKET_SIZE)
+
+	connection, err := net.DialTimeout(TCP_PROTOCOL, address, time.Second*2)
+	if err != nil {
+		return stats, err
+	}
+	defer connection.Close()
+
+	connection.SetDeadline(time.Now().Add(time.Second * 2))
+	bytes, err := json.Marshal(ServiceCall{Name: HEALTH_CHECK_CALL})
+	if err != nil {
+		return stats, err
+	}
+	_, err = connection.Write(bytes)
+	if err != nil {
+		return stats, err
+	}
+	length, err := connection.Read(buffer)
+	if err != nil {
+		return stats, err
+	}
+	err = json.Unmarshal(buffer[:length], &serviceresult)
+	if err != nil {
+		return stats, ErrHealthCheckUnsupported
+	}
+	err = json.Unmarshal([]byte(serviceresult.Result), &stats)
+	if err != nil || stats.Started.IsZero() {
+		return stats, ErrHealthCheckUnsupported
+	}
+
+	return stats, nil
+}
+
+// Checks the health of the instance at the given address. Instances whose
+// health is unknown aren't called again, since their handler would receive
+// HEALTH_CHECK_CALL; it's only checked whether they accept connections.
+func checkInstanceHealth(address string, previous InstanceHealth) InstanceHealth {
+	health := InstanceHealth{Healthy: true, Checked: time.Now()}
+	if previous.Unknown {
+		connection, err := net.DialTimeout(TCP_PROTOCOL, address, time.Second*2)
+		if err != nil {
+			health.Healthy, health.Error = false, err.Error()
+		} else {
+			connection.Close()
+		}
+		health.Unknown = true
+		return health
+	}
+
+	stats, err := CheckServiceHealth(address)
+	switch {
+	case err == ErrHealthCheckUnsupported:
+		health.Unknown = true
+	case err != nil:
+		health.Healthy, health.Error = false, err.Error()
+	}
+	health.Stats = stats
+	return health
+}
+
+// Periodically checks the health of all registered instances and of the
+// proxied peer instances, with at most REGISTRY_HEALTH_WORKERS checks at
+// the same time.
+func registryHealthService() {
+	slots := make(chan struct{}, REGISTRY_HEALTH_WORKERS)
+	for {
+		servicesLock.Lock()
+		addresses := make(map[string]string)
+		previous := make(map[string]InstanceHealth)
+		for name, instances := range services {
+			for _, instance := range instances {
+				addresses[instance.Address] = name
+				previous[instance.Address] = instanceHealth[instance.Address]
+			}
+		}
+		servicesLock.Unlock()
+
+		var wait sync.WaitGroup
+		for address, name := range addresses {
+			wait.Add(1)
+			slots <- struct{}{}
+			go func(address, name string) {
+				defer wait.Done()
+				defer func() { <-slots }()
+				updateInstanceHealth(address, name, checkInstanceHealth(address, previous[address]))
+			}(address, name)
+		}
+		wait.Wait()
+		if REGISTRY_PROXY {
+			checkProxyHealth(slots)
+		}
+
+		time.Sleep(REGISTRY_HEALTH_INTERVAL)
+	}
+}
+
+// Stores the health of an instance and publishes an event if it changed.
+// Instances which were deregistered or registered again during the check
+// are skipped, since the result belongs to the old instance.
+func updateInstanceHealth(address, name string, health InstanceHealth) {
+	servicesLock.Lock()
+	defer servicesLock.Unlock()
+
+	registered := false
+	for _, instance := range services[name] {
+		registered = registered || instance.Address == address
+	}
+	previous, checked := instanceHealth[address]
+	if !registered || healthReset[address].After(health.Checked) {
+		return
+	}
+
+	instanceHealth[address] = health
+	if health.Healthy != previous.Healthy && (checked || !health.Healthy) {
+		event := RegistryEvent{EVENT_UNHEALTHY, name, address}
+		if health.Healthy {
+			event.Type = EVENT_HEALTHY
+		}
+		publishEvent(event)
+	}
+}
+
+// Returns the status of all locally registered instances of the service,
+// or of all services if name is empty. The caller must hold servicesLock.
+func instanceStatus(name string) []InstanceStatus {
+	result := []InstanceStatus{}
+	for serviceName, instances := range services {
+		if name != "" && serviceName != name {
+			continue
+		}
+		for _, instance := range instances {
+			result = append(result, InstanceStatus{instance, instanceHealth[instance.Address], drainedInstances[instance.Address]})
+		}
+	}
+	return result
+}
+
+// Removes registered instances of a service. If address is empty, all
+// instances are removed. Returns the number of removed instances. The
+// caller must hold servicesLock.
+func deregisterInstances(name, address string) int {
+	kept := []ServiceInfoAddress{}
+	for _, instance := range services[name] {
+		if address == "" || instance.Address == address {
+			delete(instanceHealth, instance.Address)
+			delete(healthReset, instance.Address)
+			delete(drainedInstances, instance.Address)
+			publishEvent(RegistryEvent{EVENT_DEREGISTERED, name, instance.Address})
+		} else {
+			kept = append(kept, instance)
+		}
+	}
+
+	removed := len(services[name]) - len(kept)
+	if len(kept) == 0 {
+		delete(services, name)
+	} else {
+		services[name] = kept
+	}
+	return removed
+}
+
+// Marks registered instances of a service as drained (or not drained).
+// If address is empty, all instances are marked. Returns the number of
+// marked instances. The caller must hold servicesLock.
+func drainInstances(name, address string, drain bool) int {
+	count := 0
+	for _, instance := range services[name] {
+		if address == "" || instance.Address == address {
+			if drain {
+				drainedInstances[instance.Address] = true
+				publishEvent(RegistryEvent{EVENT_DRAINED, name, instance.Address})
+			} else {
+				delete(drainedInstances, instance.Address)
+				publishEvent(RegistryEvent{EVENT_UNDRAINED, name, instance.Address})
+			}
+			count++
+		}
+	}
+	return count
+}
+
+// Writes the value as JSON response with the given status code.
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(value)
+}
+
+// Writes an error as JSON response ({"Error": "..."}).
+func writeJSONError(writer http.ResponseWriter, status int, message string) {
+	writeJSON(writer, status, map[string]string{"Error": message})
+}
+
+// Returns the IP of the registry as seen by the HTTP client.
+func requestLocalIP(request *http.Request) net.IP {
+	if address, ok := request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if tcpAddress, ok := address.(*net.TCPAddr); ok {
+			return tcpAddress.IP
+		}
+	}
+	return net.ParseIP("127.0.0.1")
+}
+
+// GET /services[?namespace=ns] lists all services (like OPERATION_LIST).
+// GET /services/{name}[?version=^1.2] returns the contract of a service.
+// DELETE /services/{name}[?address=host:port] deregisters instances.
+func handleHTTPServices(writer http.ResponseWriter, request *http.Request) {
+	name := CanonicalServiceName(strings.TrimPrefix(request.URL.Path, "/services/"))
+	if request.URL.Path == "/services" || request.URL.Path == "/services/" {
+		name = ""
+	}
+
+	servicesLock.Lock()
+	defer servicesLock.Unlock()
+
+	switch {
+	case request.Method == "GET":
+		lookuprequest := LookupInfoRequest{OPERATION_INFO, name, request.URL.Query().Get("version")}
+		if name == "" {
+			lookuprequest = LookupInfoRequest{OPERATION_LIST, request.URL.Query().Get("namespace"), ""}
+		}
+		bytes, _ := lookupResponse(&lookuprequest, knownServices(requestLocalIP(request)))
+		if name != "" {
+			instance := ServiceInfoAddress{}
+			if json.Unmarshal(bytes, &instance) != nil || instance.Address == "" {
+				writeJSONError(writer, http.StatusNotFound, "service not found")
+				return
+			}
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(bytes)
+	case request.Method == "DELETE" && name != "":
+		if !authorizedRequest(writer, request) {
+			return
+		}
+		removed := deregisterInstances(name, request.URL.Query().Get("address"))
+		if removed == 0 {
+			writeJSONError(writer, http.StatusNotFound, "service not found")
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]int{"Deregistered": removed})
+	default:
+		writeJSONError(writer, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// GET /health returns the status of all registered instances.
+// GET /health/{name} returns the status of the instances of a service.
+func handleHTTPHealth(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		writeJSONError(writer, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := ""
+	if strings.HasPrefix(request.URL.Path, "/health/") {
+		name = CanonicalServiceName(strings.TrimPrefix(request.URL.Path, "/health/"))
+	}
+
+	servicesLock.Lock()
+	defer servicesLock.Unlock()
+
+	status := instanceStatus(name)
+	if name != "" && len(status) == 0 {
+		writeJSONError(writer, http.StatusNotFound, "service not found")
+		return
+	}
+	writeJSON(writer, http.StatusOK, status)
+}
+
+// PUT or POST /drain/{name}[?address=host:port] drains instances, so
+// that they are no longer handed out by lookups.
+// DELETE /drain/{name}[?address=host:port] ends draining.
+func handleHTTPDrain(writer http.ResponseWriter, request *http.Request) {
+	name := CanonicalServiceName(strings.TrimPrefix(request.URL.Path, "/drain/"))
+	drain := request.Method == "PUT" || request.Method == "POST"
+	if !drain && request.Method != "DELETE" {
+		writeJSONError(writer, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !authorizedRequest(writer, request) {
+		return
+	}
+
+	servicesLock.Lock()
+	defer servicesLock.Unlock()
+
+	count := drainInstances(name, request.URL.Query().Get("address"), drain)
+	if count == 0 {
+		writeJSONError(writer, http.StatusNotFound, "service not found")
+		return
+	}
+	writeJSON(writer, http.StatusOK, instanceStatus(name))
+}
+
+// Returns true if the request carries REGISTRY_HTTP_TOKEN, otherwise
+// answers it with 401.
+func authorizedRequest(writer http.ResponseWriter, request *http.Request) bool {
+	if REGISTRY_HTTP_TOKEN == "" {
+		return true
+	}
+	expected := "Bearer " + REGISTRY_HTTP_TOKEN
+	if subtle.ConstantTimeCompare([]byte(request.Header.Get("Authorization")), []byte(expected)) == 1 {
+		return true
+	}
+	writer.Header().Set("WWW-Authenticate", "Bearer")
+	writeJSONError(writer, http.StatusUnauthorized, "unauthorized")
+	return false
+}
+
+// Returns the handler of the HTTP admin API and the dashboard of the registry.
+func registryHTTPHandler() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", handleHTTPServices)
+	mux.HandleFunc("/services/", handleHTTPServices)
+	mux.HandleFunc("/health", handleHTTPHealth)
+	mux.HandleFunc("/health/", handleHTTPHealth)
+	mux.HandleFunc("/drain/", handleHTTPDrain)
+	mux.HandleFunc("/", handleHTTPDashboard)
+	mux.HandleFunc("/call/", handleHTTPCall)
+	mux.HandleFunc("/openapi.json", handleHTTPOpenAPI)
+	mux.HandleFunc("/schemas/", handleHTTPSchema)
+	return mux
+}
+
+// Listens on REGISTRY_HTTP_HOST and REGISTRY_HTTP_PORT and serves the
+// HTTP admin API of the registry in the background.
+func startRegistryHTTPServer() error {
+	listener, err := net.Listen(TCP_PROTOCOL, net.JoinHostPort(REGISTRY_HTTP_HOST, strconv.Itoa(REGISTRY_HTTP_PORT)))
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := http.Serve(listener, registryHTTPHandler())
+		fmt.Println("error: HTTP admin API stopped:", err)
+	}()
+	return nil
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	// Maximum number of asynchronous calls (CallServiceAsync()) which run
+	// at the same time. Further calls wait until one of them is done.
+	ASYNC_WORKERS = 32
+	// Maximum number of calls of a batch (CallBatch()) which run at the
+	// same time.
+	BATCH_WORKERS = 8
+	// Slots of the running asynchronous calls.
+	asyncSlots     chan struct{}
+	asyncSlotsOnce sync.Once
+)
+
+// Result of an asynchronous service call, which is available when the call
+// is done.
+type Future struct {
+	done   chan struct{}
+	result string
+	err    error
+}
+
+// Returns a channel which is closed when the call is done.
+func (future *Future) Done() <-chan struct{} {
+	return future.done
+}
+
+// Waits until the call is done and returns its result.
+func (future *Future) Result() (string, error) {
+	<-future.done
+	return future.result, future.err
+}
+
+// Waits until the call is done or the context is canceled and returns the
+// result or the error of the context. Canceling this context doesn't abort
+// the call itself.
+func (future *Future) Wait(ctx context.Context) (string, error) {
+	select {
+	case <-future.done:
+		return future.result, future.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Invokes the service specified by name with the given arguments in the
+// background and returns immediately.
+func CallServiceAsync(name string, args ...string) *Future {
+	return CallServiceAsyncContext(context.Background(), name, "", args...)
+}
+
+// Same as CallServiceContext(), but invokes the service in the background
+// and returns immediately. At most ASYNC_WORKERS calls run at the same
+// time.
+func CallServiceAsyncContext(ctx context.Context, name, constraint string, args ...string) *Future {
+	asyncSlotsOnce.Do(func() {
+		asyncSlots = make(chan struct{}, ASYNC_WORKERS)
+	})
+
+	future := &Future{done: make(chan struct{})}
+	go func() {
+		defer close(future.done)
+		select {
+		case asyncSlots <- struct{}{}:
+			defer func() { <-asyncSlots }()
+		case <-ctx.Done():
+			future.err = ctx.Err()
+			return
+		}
+		future.result, future.err = CallServiceContext(ctx, name, constraint, args...)
+	}()
+	return future
+}
+
+// One call of a batch.
+type BatchCall struct {
+	Name       string
+	Constraint string
+	Arguments  []string
+}
+
+// Result of one call of a batch.
+type BatchResult struct {
+	Result string
+	Err    error
+}
+
+// Invokes all calls of the batch concurrently, with at most BATCH_WORKERS
+// calls at the same time, and waits until all are done. Returns the results
+// in the order of the calls; a failed call doesn't abort the others. Calls
+// which haven't started when the context is canceled fail with its error.
+func CallBatch(ctx context.Context, calls []BatchCall) []BatchResult {
+	results := make([]BatchResult, len(calls))
+	indices := make(chan int)
+	workers := BATCH_WORKERS
+	if workers <= 0 || workers > len(calls) {
+		workers = len(calls)
+	}
+
+	var wait sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for index := range indices {
+				call := calls[index]
+				if ctx.Err() != nil {
+					results[index].Err = ctx.Err()
+					continue
+				}
+				results[index].Result, results[index].Err = CallServiceContext(ctx, call.Name, call.Constraint, call.Arguments...)
+			}
+		}()
+	}
+	for index := range calls {
+		indices <- index
+	}
+	close(indices)
+	wait.Wait()
+	return results
+}
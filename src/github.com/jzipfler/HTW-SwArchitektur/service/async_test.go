@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Answers all calls with a client interceptor instead of a service:
+// "upper" returns its argument in upper case, "slow" waits until release
+// is closed and other names fail.
+func fakeCalls(release chan struct{}) {
+	AddClientInterceptor(func(ctx context.Context, servicecall *ServiceCall, next ClientInvoker) (string, error) {
+		switch servicecall.Name {
+		case "upper":
+			return strings.ToUpper(strings.Join(servicecall.Arguments, " ")), nil
+		case "slow":
+			select {
+			case <-release:
+				return "slow", nil
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		return "", &ServiceError{SERVICE_NOT_FOUND, "service " + servicecall.Name + " not found"}
+	})
+}
+
+func TestCallServiceAsync(t *testing.T) {
+	defer resetInterceptors()
+	release := make(chan struct{})
+	fakeCalls(release)
+
+	future := CallServiceAsync("upper", "a", "b")
+	if result, err := future.Result(); err != nil || result != "A B" {
+		t.Errorf("Result() = %q, %v, want %q", result, err, "A B")
+	}
+	future = CallServiceAsync("missing")
+	if _, err := future.Result(); err == nil {
+		t.Error("Result() of a failed call returned no error")
+	}
+
+	// waiting can be aborted, the call goes on
+	future = CallServiceAsync("slow")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := future.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-future.Done():
+		t.Error("call was done before the service answered")
+	default:
+	}
+
+	// canceling the context of a call aborts it
+	canceled, cancelCall := context.WithCancel(context.Background())
+	cancelCall()
+	if _, err := CallServiceAsyncContext(canceled, "slow", "").Result(); err != context.Canceled {
+		t.Errorf("Result() of a canceled call = %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+	if result, err := future.Wait(context.Background()); err != nil || result != "slow" {
+		t.Errorf("Wait() = %q, %v, want slow", result, err)
+	}
+}
+
+func TestCallBatch(t *testing.T) {
+	defer resetInterceptors()
+	defer func(workers int) { BATCH_WORKERS = workers }(BATCH_WORKERS)
+	BATCH_WORKERS = 2
+
+	// count the calls which run at the same time
+	var lock sync.Mutex
+	running, maximum := 0, 0
+	AddClientInterceptor(func(ctx context.Context, servicecall *ServiceCall, next ClientInvoker) (string, error) {
+		lock.Lock()
+		running++
+		if running > maximum {
+			maximum = running
+		}
+		lock.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		defer func() {
+			lock.Lock()
+			running--
+			lock.Unlock()
+		}()
+		return next(ctx, servicecall)
+	})
+	fakeCalls(nil)
+
+	calls := []BatchCall{
+		{Name: "upper", Arguments: []string{"a"}},
+		{Name: "missing"},
+		{Name: "upper", Arguments: []string{"b"}},
+		{Name: "missing"},
+		{Name: "upper", Arguments: []string{"c"}},
+	}
+	results := CallBatch(context.Background(), calls)
+	for i, want := range []string{"A", "", "B", "", "C"} {
+		if results[i].Result != want || (results[i].Err != nil) != (want == "") {
+			t.Errorf("result %d = %q, %v, want %q", i, results[i].Result, results[i].Err, want)
+		}
+	}
+	serviceError := &ServiceError{}
+	if !errors.As(results[1].Err, &serviceError) || serviceError.Code != SERVICE_NOT_FOUND {
+		t.Errorf("error of a failed call = %v, want %s", results[1].Err, SERVICE_NOT_FOUND)
+	}
+	if maximum > BATCH_WORKERS {
+		t.Errorf("%d calls ran at the same time, want at most %d", maximum, BATCH_WORKERS)
+	}
+
+	// calls fail with the error of a canceled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, result := range CallBatch(ctx, calls) {
+		if result.Err != context.Canceled {
+			t.Errorf("result %d of a canceled batch = %q, %v, want %v", i, result.Result, result.Err, context.Canceled)
+		}
+	}
+	if results := CallBatch(context.Background(), nil); len(results) != 0 {
+		t.Errorf("%d results of an empty batch", len(results))
+	}
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// State of a circuit breaker.
+type BreakerState int
+
+const (
+	// Calls pass, failures are counted.
+	BREAKER_CLOSED BreakerState = iota
+	// Calls are rejected with ErrCircuitOpen.
+	BREAKER_OPEN
+	// A limited number of trial calls pass; a success closes the breaker,
+	// a failure opens it again.
+	BREAKER_HALF_OPEN
+)
+
+// Returns the name of the state.
+func (state BreakerState) String() string {
+	switch state {
+	case BREAKER_OPEN:
+		return "open"
+	case BREAKER_HALF_OPEN:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Policy of the circuit breakers of a service and its instances.
+type BreakerPolicy struct {
+	// Number of consecutive failed calls which open the breaker (0 = no
+	// circuit breaker).
+	FailureThreshold int
+	// Duration the breaker stays open before it allows trial calls.
+	OpenTimeout time.Duration
+	// Maximum number of concurrent trial calls while half-open.
+	HalfOpenCalls int
+}
+
+// Change of the state of a circuit breaker. Instance is empty for the
+// breaker of the whole service.
+type BreakerEvent struct {
+	Service  string
+	Instance string
+	From     BreakerState
+	To       BreakerState
+	Time     time.Time
+}
+
+var (
+	// Circuit breaker policy of all services without policy in
+	// BREAKER_POLICIES.
+	DEFAULT_BREAKER_POLICY = BreakerPolicy{FailureThreshold: 5, OpenTimeout: 10 * time.Second, HalfOpenCalls: 1}
+	// Circuit breaker policies by service name.
+	BREAKER_POLICIES = make(map[string]BreakerPolicy)
+	// Error of calls rejected by an open circuit breaker.
+	ErrCircuitOpen = errors.New("error: circuit breaker open")
+	// Circuit breakers of the client.
+	breakers = breakerMap{breakers: make(map[breakerKey]*circuitBreaker)}
+)
+
+// Identifies the breaker of a service or of an instance of it.
+type breakerKey struct {
+	service  string
+	instance string
+}
+
+// State of a circuit breaker.
+type circuitBreaker struct {
+	state    BreakerState
+	failures int
+	opened   time.Time
+	trials   int
+}
+
+// Circuit breakers by service and instance, and the listeners for their
+// state changes.
+type breakerMap struct {
+	lock      sync.Mutex
+	breakers  map[breakerKey]*circuitBreaker
+	listeners []func(BreakerEvent)
+}
+
+// Returns the circuit breaker policy of a service.
+func breakerPolicy(name string) BreakerPolicy {
+	if policy, ok := BREAKER_POLICIES[CanonicalServiceName(name)]; ok {
+		return policy
+	}
+	return DEFAULT_BREAKER_POLICY
+}
+
+// Registers a function which is called on every state change of a circuit
+// breaker, e.g. for logging or metrics.
+func OnBreakerStateChange(listener func(BreakerEvent)) {
+	breakers.lock.Lock()
+	defer breakers.lock.Unlock()
+	breakers.listeners = append(breakers.listeners, listener)
+}
+
+// Returns the states of the circuit breakers of a service and its
+// instances (by address, "" for the service).
+func BreakerStates(name string) map[string]BreakerState {
+	breakers.lock.Lock()
+	defer breakers.lock.Unlock()
+
+	states := make(map[string]BreakerState)
+	for key, breaker := range breakers.breakers {
+		if key.service == CanonicalServiceName(name) {
+			states[key.instance] = breaker.state
+		}
+	}
+	return states
+}
+
+// Changes the state of a breaker and returns the event. The caller must
+// hold the lock.
+func (breaker *circuitBreaker) change(key breakerKey, state BreakerState) BreakerEvent {
+	event := BreakerEvent{key.service, key.instance, breaker.state, state, time.Now()}
+	breaker.state = state
+	switch state {
+	case BREAKER_OPEN:
+		breaker.opened = event.Time
+	case BREAKER_CLOSED:
+		breaker.failures = 0
+	}
+	breaker.trials = 0
+	return event
+}
+
+// Calls the listeners for the events.
+func (breakers *breakerMap) notify(events []BreakerEvent) {
+	if len(events) == 0 {
+		return
+	}
+	breakers.lock.Lock()
+	listeners := breakers.listeners
+	breakers.lock.Unlock()
+
+	for _, event := range events {
+		for _, listener := range listeners {
+			listener(event)
+		}
+	}
+}
+
+// Returns the breakers of a service and an instance (if address isn't
+// empty). The caller must hold the lock.
+func (breakers *breakerMap) get(name, address string) map[breakerKey]*circuitBreaker {
+	keys := []breakerKey{{name, ""}}
+	if address != "" {
+		keys = append(keys, breakerKey{name, address})
+	}
+
+	result := make(map[breakerKey]*circuitBreaker)
+	for _, key := range keys {
+		breaker, ok := breakers.breakers[key]
+		if !ok {
+			breaker = &circuitBreaker{}
+			breakers.breakers[key] = breaker
+		}
+		result[key] = breaker
+	}
+	return result
+}
+
+// Returns true if a call of the service (at the instance, if address isn't
+// empty) may pass the circuit breakers, otherwise whether the breaker of
+// the whole service rejected the call. Calls which pass must be followed
+// by record().
+func (breakers *breakerMap) allow(name, address string) (ok, serviceOpen bool) {
+	policy := breakerPolicy(name)
+	if policy.FailureThreshold <= 0 {
+		return true, false
+	}
+	name = CanonicalServiceName(name)
+
+	events := []BreakerEvent{}
+	defer func() { breakers.notify(events) }()
+	breakers.lock.Lock()
+	defer breakers.lock.Unlock()
+
+	selected := breakers.get(name, address)
+	for key, breaker := range selected {
+		if breaker.state == BREAKER_OPEN && time.Since(breaker.opened) >= policy.OpenTimeout {
+			events = append(events, breaker.change(key, BREAKER_HALF_OPEN))
+		}
+		if breaker.state == BREAKER_OPEN || (breaker.state == BREAKER_HALF_OPEN && breaker.trials >= policy.HalfOpenCalls) {
+			return false, key.instance == ""
+		}
+	}
+	for _, breaker := range selected {
+		if breaker.state == BREAKER_HALF_OPEN {
+			breaker.trials++
+		}
+	}
+	return true, false
+}
+
+// Records the outcome of a call which passed allow(). Errors which don't
+// indicate a problem of the service (e.g. a canceled context) only end
+// the call.
+func (breakers *breakerMap) record(name, address string, err error) {
+	success := err == nil
+	failure := ClassifyError(err)&(ERROR_CONNECT|ERROR_CONNECTION|ERROR_TIMEOUT|ERROR_OVERLOADED) != 0
+
+	policy := breakerPolicy(name)
+	if policy.FailureThreshold <= 0 {
+		return
+	}
+	name = CanonicalServiceName(name)
+
+	events := []BreakerEvent{}
+	defer func() { breakers.notify(events) }()
+	breakers.lock.Lock()
+	defer breakers.lock.Unlock()
+
+	for key, breaker := range breakers.get(name, address) {
+		switch {
+		case success && breaker.state != BREAKER_CLOSED:
+			events = append(events, breaker.change(key, BREAKER_CLOSED))
+		case success:
+			breaker.failures = 0
+		case !failure:
+			if breaker.trials > 0 {
+				breaker.trials--
+			}
+		case breaker.state == BREAKER_HALF_OPEN:
+			events = append(events, breaker.change(key, BREAKER_OPEN))
+		case breaker.state == BREAKER_CLOSED:
+			breaker.failures++
+			if breaker.failures >= policy.FailureThreshold {
+				events = append(events, breaker.change(key, BREAKER_OPEN))
+			}
+		}
+	}
+}
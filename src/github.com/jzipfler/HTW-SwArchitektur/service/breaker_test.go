@@ -0,0 +1,87 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	defer delete(BREAKER_POLICIES, "flaky")
+	BREAKER_POLICIES["flaky"] = BreakerPolicy{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond, HalfOpenCalls: 1}
+
+	failure := errors.New("error: connection reset")
+	rejected := &ServiceError{"invalid", "not a number"}
+	tests := []struct {
+		name    string
+		err     error
+		wait    bool
+		allowed bool
+		state   BreakerState
+	}{
+		{"first failure", failure, false, true, BREAKER_CLOSED},
+		{"success resets failures", nil, false, true, BREAKER_CLOSED},
+		{"failure", failure, false, true, BREAKER_CLOSED},
+		{"error of the handler", rejected, false, true, BREAKER_CLOSED},
+		{"threshold reached", failure, false, true, BREAKER_OPEN},
+		{"open rejects", nil, false, false, BREAKER_OPEN},
+		{"failed trial", failure, true, true, BREAKER_OPEN},
+		{"open again", nil, false, false, BREAKER_OPEN},
+		{"successful trial", nil, true, true, BREAKER_CLOSED},
+	}
+
+	breakers := &breakerMap{breakers: make(map[breakerKey]*circuitBreaker)}
+	for _, test := range tests {
+		if test.wait {
+			time.Sleep(25 * time.Millisecond)
+		}
+		allowed, _ := breakers.allow("flaky", "10.0.0.2:4000")
+		if allowed {
+			breakers.record("flaky", "10.0.0.2:4000", test.err)
+		}
+		for _, key := range []breakerKey{{"flaky", ""}, {"flaky", "10.0.0.2:4000"}} {
+			if state := breakers.breakers[key].state; allowed != test.allowed || state != test.state {
+				t.Errorf("%s: allowed %v, %v breaker %s, want %v, %s", test.name, allowed, key, state, test.allowed, test.state)
+			}
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenCalls(t *testing.T) {
+	defer delete(BREAKER_POLICIES, "flaky")
+	BREAKER_POLICIES["flaky"] = BreakerPolicy{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond, HalfOpenCalls: 1}
+
+	breakers := &breakerMap{breakers: make(map[breakerKey]*circuitBreaker)}
+	breakers.allow("flaky", "")
+	breakers.record("flaky", "", errors.New("error: connection refused"))
+	time.Sleep(15 * time.Millisecond)
+
+	if allowed, _ := breakers.allow("flaky", ""); !allowed {
+		t.Fatal("allow() rejected the trial call")
+	}
+	if allowed, serviceOpen := breakers.allow("flaky", ""); allowed || !serviceOpen {
+		t.Errorf("allow() = %v, %v during the trial call, want false, true", allowed, serviceOpen)
+	}
+
+	// a trial call failing in the handler frees its slot without changing the state
+	breakers.record("flaky", "", &ServiceError{"invalid", "not a number"})
+	if allowed, _ := breakers.allow("flaky", ""); !allowed {
+		t.Error("allow() rejected a trial call after the previous one ended")
+	}
+	if state := breakers.breakers[breakerKey{"flaky", ""}].state; state != BREAKER_HALF_OPEN {
+		t.Errorf("state = %s, want %s", state, BREAKER_HALF_OPEN)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	defer delete(BREAKER_POLICIES, "flaky")
+	BREAKER_POLICIES["flaky"] = BreakerPolicy{}
+
+	breakers := &breakerMap{breakers: make(map[breakerKey]*circuitBreaker)}
+	for i := 0; i < 10; i++ {
+		if allowed, _ := breakers.allow("flaky", ""); !allowed {
+			t.Fatal("allow() rejected a call without circuit breaker")
+		}
+		breakers.record("flaky", "", errors.New("error: connection refused"))
+	}
+}
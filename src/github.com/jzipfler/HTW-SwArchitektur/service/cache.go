@@ -0,0 +1,100 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// Time to live of cached lookups of service addresses and contracts
+	// (0 = no caching).
+	LOOKUP_CACHE_TTL = 30 * time.Second
+	// Whether the cache watches the registry (see WatchRegistry()) and
+	// invalidates entries of changed services immediately.
+	LOOKUP_CACHE_WATCH = true
+	// Delay before the watch is opened again after the connection to the
+	// registry was lost.
+	LOOKUP_CACHE_WATCH_RETRY = 5 * time.Second
+	// Cached lookups of the client.
+	lookupCache = lookupCacheMap{entries: make(map[LookupInfoRequest]cachedLookup)}
+)
+
+// A cached lookup result with its expiry.
+type cachedLookup struct {
+	value   interface{}
+	expires time.Time
+}
+
+// Cached lookups by request (with canonical service name).
+type lookupCacheMap struct {
+	lock    sync.Mutex
+	entries map[LookupInfoRequest]cachedLookup
+	watch   sync.Once
+}
+
+// Returns the cached result of a lookup, if it's not expired.
+func (cache *lookupCacheMap) get(operation, name, constraint string) (interface{}, bool) {
+	if LOOKUP_CACHE_TTL <= 0 {
+		return nil, false
+	}
+	if LOOKUP_CACHE_WATCH && DISCOVERY_MODE == DISCOVERY_REGISTRY {
+		cache.watch.Do(func() { go cache.watchRegistry() })
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	entry, ok := cache.entries[LookupInfoRequest{operation, CanonicalServiceName(name), constraint}]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Caches the result of a lookup for LOOKUP_CACHE_TTL.
+func (cache *lookupCacheMap) put(operation, name, constraint string, value interface{}) {
+	if LOOKUP_CACHE_TTL <= 0 {
+		return
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.entries[LookupInfoRequest{operation, CanonicalServiceName(name), constraint}] = cachedLookup{value, time.Now().Add(LOOKUP_CACHE_TTL)}
+}
+
+// Removes the cached lookups of a service, or all cached lookups if name
+// is empty.
+func (cache *lookupCacheMap) invalidate(name string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	name = CanonicalServiceName(name)
+	for request := range cache.entries {
+		if name == "" || request.ServiceName == name {
+			delete(cache.entries, request)
+		}
+	}
+}
+
+// Invalidates cached lookups on changes announced by the registry. While
+// the registry can't be watched, the entries only expire after their TTL.
+func (cache *lookupCacheMap) watchRegistry() {
+	for {
+		events, err := WatchRegistry()
+		if err == nil {
+			for event := range events {
+				cache.invalidate(event.Name)
+			}
+			// events may have been missed
+			cache.invalidate("")
+		}
+		time.Sleep(LOOKUP_CACHE_WATCH_RETRY)
+	}
+}
+
+// Removes the cached address and contract of a service, e.g. after it
+// couldn't be reached. If name is empty, the whole cache is cleared.
+func InvalidateLookupCache(name string) {
+	lookupCache.invalidate(name)
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLookupCacheExpiry(t *testing.T) {
+	defer func(ttl time.Duration, watch bool) { LOOKUP_CACHE_TTL, LOOKUP_CACHE_WATCH = ttl, watch }(LOOKUP_CACHE_TTL, LOOKUP_CACHE_WATCH)
+	LOOKUP_CACHE_TTL, LOOKUP_CACHE_WATCH = 50*time.Millisecond, false
+	cache := lookupCacheMap{entries: make(map[LookupInfoRequest]cachedLookup)}
+
+	cache.put(OPERATION_ADDRESS, "isprime", "", "10.0.0.2:4000")
+	if value, ok := cache.get(OPERATION_ADDRESS, "isprime", ""); !ok || value != "10.0.0.2:4000" {
+		t.Errorf("get() = %v, %t, want the cached address", value, ok)
+	}
+	if _, ok := cache.get(OPERATION_ADDRESS, "isprime", "^1.0.0"); ok {
+		t.Error("get() with another constraint returned the cached address")
+	}
+	if _, ok := cache.get(OPERATION_INFO, "isprime", ""); ok {
+		t.Error("get() for another operation returned the cached address")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := cache.get(OPERATION_ADDRESS, "isprime", ""); ok {
+		t.Error("get() returned an expired address")
+	}
+
+	LOOKUP_CACHE_TTL = 0
+	cache.put(OPERATION_ADDRESS, "isprime", "", "10.0.0.2:4000")
+	if _, ok := cache.get(OPERATION_ADDRESS, "isprime", ""); ok {
+		t.Error("get() returned an address with caching disabled")
+	}
+}
+
+func TestLookupCacheInvalidate(t *testing.T) {
+	defer func(ttl time.Duration, watch bool) { LOOKUP_CACHE_TTL, LOOKUP_CACHE_WATCH = ttl, watch }(LOOKUP_CACHE_TTL, LOOKUP_CACHE_WATCH)
+	LOOKUP_CACHE_TTL, LOOKUP_CACHE_WATCH = time.Minute, false
+	cache := lookupCacheMap{entries: make(map[LookupInfoRequest]cachedLookup)}
+	fill := func() {
+		cache.put(OPERATION_ADDRESS, "isprime", "", "10.0.0.2:4000")
+		cache.put(OPERATION_ADDRESS, "default/isprime", "^1.0.0", "10.0.0.2:4000")
+		cache.put(OPERATION_ADDRESS, "team-a/random", "", "10.0.0.3:4000")
+	}
+	tests := []struct {
+		invalidate string
+		remaining  int
+	}{
+		{"isprime", 1},
+		{"default/isprime", 1},
+		{"team-a/random", 2},
+		{"random", 3},
+		{"", 0},
+	}
+
+	for _, test := range tests {
+		fill()
+		cache.invalidate(test.invalidate)
+		if len(cache.entries) != test.remaining {
+			t.Errorf("invalidate(%q) left %d entries, want %d", test.invalidate, len(cache.entries), test.remaining)
+		}
+	}
+}
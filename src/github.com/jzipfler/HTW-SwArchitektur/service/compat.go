@@ -0,0 +1,137 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Describes a single difference between two versions of a service contract.
+// Breaking changes are changes which make existing callers fail, e.g. a
+// removed argument or a changed result type.
+type ContractChange struct {
+	Breaking    bool
+	Description string
+}
+
+// Returns the change as a single line, prefixed with its kind.
+func (c ContractChange) String() string {
+	if c.Breaking {
+		return "breaking: " + c.Description
+	}
+	return "compatible: " + c.Description
+}
+
+// Compares the contract of an old and a new version of a service and
+// returns all changes. Since arguments are passed by position, any
+// change to the number or types of arguments is a breaking change,
+// while renamed arguments or changed descriptions are compatible.
+func CompareContracts(older, newer *ServiceInfo) []ContractChange {
+	changes := []ContractChange{}
+	add := func(breaking bool, format string, args ...interface{}) {
+		changes = append(changes, ContractChange{breaking, fmt.Sprintf(format, args...)})
+	}
+
+	if older.Name != newer.Name {
+		add(true, "service name changed from %q to %q", older.Name, newer.Name)
+	}
+	if older.ResultType != newer.ResultType {
+		add(true, "result type changed from %q to %q", older.ResultType, newer.ResultType)
+	}
+	if older.Description != newer.Description {
+		add(false, "description changed")
+	}
+	if older.Idempotent != newer.Idempotent {
+		// clients may retry calls of idempotent services
+		add(older.Idempotent, "idempotent changed from %t to %t", older.Idempotent, newer.Idempotent)
+	}
+	if older.Streaming != newer.Streaming {
+		// streaming services have to be called with CallServiceStream()
+		add(true, "streaming changed from %t to %t", older.Streaming, newer.Streaming)
+	}
+	if older.Interactive != newer.Interactive {
+		// interactive services have to be called with OpenSession()
+		add(true, "interactive changed from %t to %t", older.Interactive, newer.Interactive)
+	}
+
+	oldArgs, newArgs := contractArguments(older), contractArguments(newer)
+	for i, arg := range oldArgs {
+		if i >= len(newArgs) {
+			add(true, "argument %d (%q) removed", i+1, arg.Name)
+			continue
+		}
+		if arg.Type != newArgs[i].Type {
+			add(true, "argument %d (%q) type changed from %q to %q", i+1, arg.Name, arg.Type, newArgs[i].Type)
+		}
+		if arg.Name != newArgs[i].Name {
+			add(false, "argument %d renamed from %q to %q", i+1, arg.Name, newArgs[i].Name)
+		}
+		if arg.Description != newArgs[i].Description {
+			add(false, "argument %d (%q) description changed", i+1, newArgs[i].Name)
+		}
+	}
+	for i := len(oldArgs); i < len(newArgs); i++ {
+		add(true, "argument %d (%q) added", i+1, newArgs[i].Name)
+	}
+
+	return changes
+}
+
+// Returns the arguments of a contract. A single "void" argument is
+// the convention for services without arguments, so it's dropped.
+func contractArguments(info *ServiceInfo) []ArgumentInfo {
+	if len(info.Arguments) == 1 && info.Arguments[0].Type == "void" {
+		return nil
+	}
+	return info.Arguments
+}
+
+// Returns true if any of the given changes is a breaking change.
+func HasBreakingChanges(changes []ContractChange) bool {
+	for _, change := range changes {
+		if change.Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks whether a new service instance may be registered next to the
+// given instances. Registering a contract which breaks compatibility
+// with another instance of the same major version is refused, because
+// callers using a constraint like "^1.2" would break. The caller must
+// hold servicesLock.
+func checkCompatibility(instances []ServiceInfoAddress, candidate ServiceInfoAddress) error {
+	version, err := ParseVersion(candidate.Info.Version)
+	if err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		if replacesInstance(instance, candidate) {
+			continue
+		}
+		other, err := ParseVersion(instance.Info.Version)
+		if err != nil || other.Major != version.Major {
+			continue
+		}
+
+		// always compare from the lower to the higher version
+		changes := CompareContracts(&instance.Info, &candidate.Info)
+		if version.Compare(other) < 0 {
+			changes = CompareContracts(&candidate.Info, &instance.Info)
+		}
+		if HasBreakingChanges(changes) {
+			messages := []string{}
+			for _, change := range changes {
+				if change.Breaking {
+					messages = append(messages, change.Description)
+				}
+			}
+			return errors.New("error: version " + version.String() + " of \"" + candidate.Info.Name +
+				"\" is incompatible with registered version " + other.String() + ": " + strings.Join(messages, ", "))
+		}
+	}
+
+	return nil
+}
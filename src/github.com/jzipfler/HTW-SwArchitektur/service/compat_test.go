@@ -0,0 +1,103 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareContracts(t *testing.T) {
+	base := ServiceInfo{
+		Name:        "isprime",
+		Version:     "1.0.0",
+		ResultType:  "string",
+		Description: "Checks whether x is prime.",
+		Arguments:   []ArgumentInfo{{"x", "int", "number to test"}},
+	}
+	void := base
+	void.Arguments = []ArgumentInfo{{"", "void", ""}}
+	none := base
+	none.Arguments = nil
+
+	tests := []struct {
+		name    string
+		change  func(info *ServiceInfo)
+		older   *ServiceInfo
+		changes []ContractChange
+	}{
+		{"unchanged", func(info *ServiceInfo) {}, &base, []ContractChange{}},
+		{"version only", func(info *ServiceInfo) { info.Version = "1.1.0" }, &base, []ContractChange{}},
+		{"name", func(info *ServiceInfo) { info.Name = "prime" }, &base,
+			[]ContractChange{{true, `service name changed from "isprime" to "prime"`}}},
+		{"result type", func(info *ServiceInfo) { info.ResultType = "bool" }, &base,
+			[]ContractChange{{true, `result type changed from "string" to "bool"`}}},
+		{"description", func(info *ServiceInfo) { info.Description = "" }, &base,
+			[]ContractChange{{false, "description changed"}}},
+		{"idempotent added", func(info *ServiceInfo) { info.Idempotent = true }, &base,
+			[]ContractChange{{false, "idempotent changed from false to true"}}},
+		{"streaming", func(info *ServiceInfo) { info.Streaming = true }, &base,
+			[]ContractChange{{true, "streaming changed from false to true"}}},
+		{"interactive", func(info *ServiceInfo) { info.Interactive = true }, &base,
+			[]ContractChange{{true, "interactive changed from false to true"}}},
+		{"argument renamed", func(info *ServiceInfo) { info.Arguments = []ArgumentInfo{{"n", "int", "number to test"}} }, &base,
+			[]ContractChange{{false, `argument 1 renamed from "x" to "n"`}}},
+		{"argument type", func(info *ServiceInfo) { info.Arguments = []ArgumentInfo{{"x", "string", "number to test"}} }, &base,
+			[]ContractChange{{true, `argument 1 ("x") type changed from "int" to "string"`}}},
+		{"argument description", func(info *ServiceInfo) { info.Arguments = []ArgumentInfo{{"x", "int", ""}} }, &base,
+			[]ContractChange{{false, `argument 1 ("x") description changed`}}},
+		{"argument added", func(info *ServiceInfo) { info.Arguments = append(info.Arguments, ArgumentInfo{"y", "int", ""}) }, &base,
+			[]ContractChange{{true, `argument 2 ("y") added`}}},
+		{"argument removed", func(info *ServiceInfo) { info.Arguments = nil }, &base,
+			[]ContractChange{{true, `argument 1 ("x") removed`}}},
+		{"void and no arguments", func(info *ServiceInfo) { info.Arguments = nil }, &void, []ContractChange{}},
+		{"void added", func(info *ServiceInfo) { info.Arguments = []ArgumentInfo{{"", "void", ""}} }, &none, []ContractChange{}},
+	}
+
+	for _, test := range tests {
+		newer := *test.older
+		newer.Arguments = append([]ArgumentInfo(nil), test.older.Arguments...)
+		test.change(&newer)
+		changes := CompareContracts(test.older, &newer)
+		if !reflect.DeepEqual(changes, test.changes) {
+			t.Errorf("%s: CompareContracts() = %v, want %v", test.name, changes, test.changes)
+		}
+	}
+}
+
+func TestCompareContractsIdempotentRemoved(t *testing.T) {
+	older := &ServiceInfo{Name: "random", ResultType: "int", Idempotent: true}
+	newer := &ServiceInfo{Name: "random", ResultType: "int"}
+
+	changes := CompareContracts(older, newer)
+	if !HasBreakingChanges(changes) {
+		t.Errorf("CompareContracts() = %v, want a breaking change", changes)
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	v1 := ServiceInfoAddress{Address: "a", Info: ServiceInfo{Name: "isprime", Version: "1.0.0", ResultType: "string",
+		Arguments: []ArgumentInfo{{"x", "int", ""}}}}
+	tests := []struct {
+		name       string
+		version    string
+		resultType string
+		address    string
+		valid      bool
+	}{
+		{"compatible", "1.1.0", "string", "b", true},
+		{"breaking same major", "1.1.0", "bool", "b", false},
+		{"breaking older minor", "1.0.0", "bool", "b", false},
+		{"breaking new major", "2.0.0", "bool", "b", true},
+		{"same address", "1.1.0", "bool", "a", true},
+	}
+
+	for _, test := range tests {
+		candidate := v1
+		candidate.Address = test.address
+		candidate.Info.Version = test.version
+		candidate.Info.ResultType = test.resultType
+		err := checkCompatibility([]ServiceInfoAddress{v1}, candidate)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: checkCompatibility() = %v, valid %v", test.name, err, test.valid)
+		}
+	}
+}
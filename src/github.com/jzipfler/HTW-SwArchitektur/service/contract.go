@@ -0,0 +1,353 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Argument and result types which may be used in contracts. "var"
+	// stands for a value of any type, "void" for no arguments.
+	CONTRACT_TYPES = []string{"int", "integer", "long", "float", "double", "number", "bool", "boolean", "string", "var", "void"}
+)
+
+// Returns an error if the contract is invalid, i.e. it has an invalid
+// name or version, no result type, arguments without name, duplicate
+// argument names or unknown types.
+func ValidateContract(info *ServiceInfo) error {
+	err := ValidateServiceName(info.Name)
+	if err != nil {
+		return err
+	}
+	_, err = ParseVersion(info.Version)
+	if err != nil {
+		return err
+	}
+	if !contractType(info.ResultType) {
+		return fmt.Errorf("error: invalid result type %q of service %q", info.ResultType, info.Name)
+	}
+
+	names := make(map[string]bool)
+	for i, argument := range info.Arguments {
+		if argument.Name == "" {
+			return fmt.Errorf("error: argument %d of service %q has no name", i+1, info.Name)
+		}
+		if names[argument.Name] {
+			return fmt.Errorf("error: duplicate argument %q of service %q", argument.Name, info.Name)
+		}
+		names[argument.Name] = true
+		if !contractType(argument.Type) || (argument.Type == "void" && len(info.Arguments) != 1) {
+			return fmt.Errorf("error: invalid type %q of argument %q", argument.Type, argument.Name)
+		}
+	}
+	return nil
+}
+
+// Returns true if the type is one of CONTRACT_TYPES.
+func contractType(name string) bool {
+	for _, known := range CONTRACT_TYPES {
+		if strings.ToLower(name) == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Loads and validates a contract from a JSON or YAML file (detected by the
+// extension .json, .yaml or .yml). The keys are the field names of
+// ServiceInfo and ArgumentInfo (case-insensitive), e.g.
+//
+//	name: isprime
+//	version: 1.0.0
+//	resultType: string
+//	description: Checks whether x is prime.
+//	arguments:
+//	  - name: x
+//	    type: int
+//	    description: number to test
+func LoadContract(path string) (*ServiceInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := ParseContract(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return info, nil
+}
+
+// Parses and validates a contract like LoadContract() in the format given
+// by a file extension (.json, .yaml or .yml), e.g. a contract embedded
+// in the binary of a service.
+func ParseContract(data []byte, extension string) (*ServiceInfo, error) {
+	switch strings.ToLower(extension) {
+	case ".yaml", ".yml":
+		value, err := parseYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		data, err = json.Marshal(yamlNumbersToStrings(value))
+		if err != nil {
+			return nil, err
+		}
+	case ".json":
+	default:
+		return nil, errors.New("error: unknown contract format " + extension)
+	}
+
+	info := ServiceInfo{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&info)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateContract(&info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Loads a contract from a JSON or YAML file (see LoadContract()) or, if
+// there is no such file, from the registry ("name" or "name@constraint").
+func LoadContractSource(source string) (*ServiceInfo, error) {
+	if _, err := os.Stat(source); err == nil {
+		return LoadContract(source)
+	}
+
+	name, constraint := source, ""
+	if index := strings.Index(source, "@"); index >= 0 {
+		name, constraint = source[:index], source[index+1:]
+	}
+	serviceInfo, err := GetServiceInfoVersion(name, constraint)
+	if err != nil {
+		return nil, err
+	}
+	if serviceInfo.Address == "" {
+		return nil, fmt.Errorf("error: service %q not found!", source)
+	}
+	return &serviceInfo.Info, nil
+}
+
+var (
+	// Numbers in YAML (decimal integers and floats) and in JSON.
+	yamlNumber = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+	jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+)
+
+// A non-empty line of a YAML document.
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// Parses the subset of YAML needed for contracts: block mappings, block
+// sequences, plain and quoted scalars, empty flow collections ([] and {})
+// and comments. The scalars true and false are returned as bool, numbers
+// as json.Number (or float64 if they aren't valid JSON numbers, e.g. .5)
+// and all other scalars as strings.
+func parseYAML(data []byte) (interface{}, error) {
+	lines := []yamlLine{}
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(stripYAMLComment(text), " \r")
+		content := strings.TrimLeft(text, " ")
+		if content == "" || content == "---" {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("error: line %d: tabs are not allowed for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{i + 1, len(text) - len(content), content})
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("error: empty document")
+	}
+
+	value, next, err := parseYAMLBlock(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if next < len(lines) {
+		return nil, fmt.Errorf("error: line %d: unexpected indentation", lines[next].number)
+	}
+	return value, nil
+}
+
+// Removes a comment ("#" at the beginning or after a space, outside of
+// quotes) from a line.
+func stripYAMLComment(text string) string {
+	quote := rune(0)
+	for i, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// Parses the block (mapping or sequence) starting at lines[start] with the
+// given indentation. Returns the value and the index of the next line.
+func parseYAMLBlock(lines []yamlLine, start, indent int) (interface{}, int, error) {
+	if lines[start].text == "-" || strings.HasPrefix(lines[start].text, "- ") {
+		return parseYAMLSequence(lines, start, indent)
+	}
+	return parseYAMLMapping(lines, start, indent)
+}
+
+// Parses a block sequence ("- item" lines).
+func parseYAMLSequence(lines []yamlLine, start, indent int) (interface{}, int, error) {
+	sequence := []interface{}{}
+	i := start
+	for i < len(lines) && lines[i].indent == indent {
+		line := lines[i]
+		if line.text != "-" && !strings.HasPrefix(line.text, "- ") {
+			break
+		}
+
+		item := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		var value interface{}
+		var err error
+		switch {
+		case item == "":
+			// the item is a nested block on the following lines
+			value, i, err = parseYAMLNested(lines, i+1, indent)
+		case isYAMLMappingEntry(item):
+			// the item is a mapping starting on the line of the "-"
+			lines[i] = yamlLine{line.number, indent + len(line.text) - len(item), item}
+			value, i, err = parseYAMLMapping(lines, i, lines[i].indent)
+		default:
+			value, err = parseYAMLScalar(item, line.number)
+			i++
+		}
+		if err != nil {
+			return nil, i, err
+		}
+		sequence = append(sequence, value)
+	}
+	return sequence, i, nil
+}
+
+// Parses a block mapping ("key: value" lines).
+func parseYAMLMapping(lines []yamlLine, start, indent int) (interface{}, int, error) {
+	mapping := make(map[string]interface{})
+	i := start
+	for i < len(lines) && lines[i].indent == indent {
+		line := lines[i]
+		if !isYAMLMappingEntry(line.text) {
+			return nil, i, fmt.Errorf("error: line %d: expected \"key: value\"", line.number)
+		}
+		separator := strings.Index(line.text, ":")
+		key := strings.TrimSpace(line.text[:separator])
+		if _, ok := mapping[key]; ok {
+			return nil, i, fmt.Errorf("error: line %d: duplicate key %q", line.number, key)
+		}
+
+		var value interface{}
+		var err error
+		item := strings.TrimSpace(line.text[separator+1:])
+		i++
+		switch {
+		case item != "":
+			value, err = parseYAMLScalar(item, line.number)
+		case i < len(lines) && lines[i].indent == indent && strings.HasPrefix(lines[i].text, "-"):
+			// sequences may be indented like the key
+			value, i, err = parseYAMLSequence(lines, i, indent)
+		default:
+			value, i, err = parseYAMLNested(lines, i, indent)
+		}
+		if err != nil {
+			return nil, i, err
+		}
+		mapping[key] = value
+	}
+	return mapping, i, nil
+}
+
+// Parses the block starting at lines[start] if it's indented more than
+// its parent, otherwise the value is null.
+func parseYAMLNested(lines []yamlLine, start, parentIndent int) (interface{}, int, error) {
+	if start >= len(lines) || lines[start].indent <= parentIndent {
+		return nil, start, nil
+	}
+	return parseYAMLBlock(lines, start, lines[start].indent)
+}
+
+// Returns true if the text is a "key: value" or "key:" entry.
+func isYAMLMappingEntry(text string) bool {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		return false
+	}
+	separator := strings.Index(text, ":")
+	return separator > 0 && (separator == len(text)-1 || text[separator+1] == ' ')
+}
+
+// Parses a scalar value.
+func parseYAMLScalar(text string, number int) (interface{}, error) {
+	switch {
+	case text == "[]":
+		return []interface{}{}, nil
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case text == "~" || text == "null":
+		return nil, nil
+	case strings.HasPrefix(text, "\""):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("error: line %d: invalid string %s", number, text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("error: line %d: invalid string %s", number, text)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	case text == "true" || text == "false":
+		return text == "true", nil
+	case yamlNumber.MatchString(text):
+		if jsonNumber.MatchString(text) {
+			return json.Number(text), nil
+		}
+		return strconv.ParseFloat(text, 64)
+	case strings.ContainsAny(text[:1], "[{|>&*!%@`"):
+		return nil, fmt.Errorf("error: line %d: unsupported YAML syntax %s", number, text)
+	}
+	return text, nil
+}
+
+// Converts all numbers of a parsed YAML document to strings. Contracts
+// have no numeric fields, so e.g. "version: 1.0" is the string "1.0".
+func yamlNumbersToStrings(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		return value.String()
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case []interface{}:
+		for i, item := range value {
+			value[i] = yamlNumbersToStrings(item)
+		}
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = yamlNumbersToStrings(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		document string
+		value    interface{}
+	}{
+		{"a: b", map[string]interface{}{"a": "b"}},
+		{"a: true\nb: false", map[string]interface{}{"a": true, "b": false}},
+		{"a: 1\nb: -2.50\nc: 1e3", map[string]interface{}{"a": json.Number("1"), "b": json.Number("-2.50"), "c": json.Number("1e3")}},
+		{"a: .5\nb: +1", map[string]interface{}{"a": 0.5, "b": float64(1)}},
+		{"a: 1.0.0\nb: True\nc: 0x10", map[string]interface{}{"a": "1.0.0", "b": "True", "c": "0x10"}},
+		{"a: \"true\"\nb: '1'", map[string]interface{}{"a": "true", "b": "1"}},
+		{"a: ~\nb: []\nc: {}", map[string]interface{}{"a": nil, "b": []interface{}{}, "c": map[string]interface{}{}}},
+		{"# comment\na: b # comment\nc: 'd # e'", map[string]interface{}{"a": "b", "c": "d # e"}},
+		{"- a\n- 2", []interface{}{"a", json.Number("2")}},
+		{"a:\n  - b: c\n    d: e\n  - f", map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": "c", "d": "e"}, "f"}}},
+		{"a:\n  b: c", map[string]interface{}{"a": map[string]interface{}{"b": "c"}}},
+	}
+
+	for _, test := range tests {
+		value, err := parseYAML([]byte(test.document))
+		if err != nil || !reflect.DeepEqual(value, test.value) {
+			t.Errorf("parseYAML(%q) = %#v, %v, want %#v", test.document, value, err, test.value)
+		}
+	}
+}
+
+func TestParseYAMLInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"a:\n\tb: c",
+		"a: \"b",
+		"a: 'b",
+		"a: [b]",
+		"a: &b c",
+		"a: b\n  c: d",
+	}
+
+	for _, document := range tests {
+		if value, err := parseYAML([]byte(document)); err == nil {
+			t.Errorf("parseYAML(%q) = %#v, want an error", document, value)
+		}
+	}
+}
+
+func TestLoadContractYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ticker.yaml")
+	document := `name: ticker
+version: 1.0
+resultType: string
+description: Streams the price of a stock.
+arguments:
+  - name: symbol
+    type: string
+    description: stock symbol
+idempotent: true
+streaming: true
+interactive: false
+`
+	err = ioutil.WriteFile(path, []byte(document), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := LoadContract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &ServiceInfo{
+		Name:        "ticker",
+		Version:     "1.0",
+		ResultType:  "string",
+		Description: "Streams the price of a stock.",
+		Arguments:   []ArgumentInfo{{"symbol", "string", "stock symbol"}},
+		Idempotent:  true,
+		Streaming:   true,
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("LoadContract() = %+v, want %+v", info, expected)
+	}
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// A service instance as shown on the dashboard.
+type dashboardInstance struct {
+	InstanceStatus
+	// arguments which have to be entered to invoke the service
+	Arguments []ArgumentInfo
+}
+
+// Data of the dashboard page.
+type dashboardPage struct {
+	Instances []dashboardInstance
+	// result of a service call, if the page shows one
+	Call   string
+	Result string
+	Error  string
+}
+
+// Template of the dashboard. It shows all services with their contract,
+// health and statistics, and a form to invoke each of them.
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Service Registry</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 0.4em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.healthy { color: green; }
+.unhealthy { color: red; }
+.result { border: 1px solid #ccc; padding: 1em; margin-bottom: 2em; white-space: pre-wrap; }
+.error { color: red; }
+</style>
+</head>
+<body>
+<h1>Service Registry</h1>
+{{if .Call}}
+<h2>Result of {{.Call}}</h2>
+{{if .Error}}<div class="result error">{{.Error}}</div>{{else}}<div class="result">{{.Result}}</div>{{end}}
+{{end}}
+<table>
+<tr><th>Service</th><th>Contract</th><th>Address</th><th>Health</th><th>Calls</th><th>Invoke</th></tr>
+{{range .Instances}}
+<tr>
+<td><b>{{.Info.Name}}</b><br>{{.Info.Version}}{{if .Origin}}<br>from {{.Origin}}{{end}}</td>
+<td>{{.Info.Description}}<br>Result: {{.Info.ResultType}}
+{{if .Arguments}}<ol>{{range .Arguments}}<li>{{.Name}} ({{.Type}}): {{.Description}}</li>{{end}}</ol>{{end}}</td>
+<td>{{.Address}}</td>
+<td>{{if .Origin}}unknown{{else if .Health.Checked.IsZero}}not checked{{else if .Health.Unknown}}{{if .Health.Healthy}}reachable{{else}}<span class="unhealthy">unreachable</span><br>{{.Health.Error}}{{end}}{{else if .Health.Healthy}}<span class="healthy">healthy</span>{{else}}<span class="unhealthy">unhealthy</span><br>{{.Health.Error}}{{end}}
+{{if .Draining}}<br>draining{{end}}</td>
+<td>{{if not (or .Health.Checked.IsZero .Health.Unknown)}}{{.Health.Stats.Calls}} calls<br>{{.Health.Stats.Active}} active{{if .Health.Stats.Queued}}<br>{{.Health.Stats.Queued}} queued{{end}}{{if .Health.Stats.Rejected}}<br>{{.Health.Stats.Rejected}} rejected{{end}}{{end}}</td>
+<td><form method="post" action="/call/{{.Info.Name}}">
+<input type="hidden" name="address" value="{{.Address}}">
+{{range .Arguments}}<input name="arg" placeholder="{{.Name}} ({{.Type}})" title="{{.Description}}"><br>{{end}}
+<input type="submit" value="Invoke">
+</form></td>
+</tr>
+{{else}}
+<tr><td colspan="6">No services registered.</td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+// Returns all local and remote instances for the dashboard, sorted by
+// name. The caller must hold servicesLock.
+func dashboardInstances() []dashboardInstance {
+	instances := []dashboardInstance{}
+	for _, status := range instanceStatus("") {
+		instances = append(instances, dashboardInstance{status, contractArguments(&status.Info)})
+	}
+	for _, catalog := range peerServices {
+		for _, remote := range catalog {
+			for _, instance := range remote {
+				instances = append(instances, dashboardInstance{InstanceStatus{ServiceInfoAddress: instance}, contractArguments(&instance.Info)})
+			}
+		}
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Info.Name != instances[j].Info.Name {
+			return instances[i].Info.Name < instances[j].Info.Name
+		}
+		return instances[i].Address < instances[j].Address
+	})
+	return instances
+}
+
+// Renders the dashboard page with the given call result.
+func renderDashboard(writer http.ResponseWriter, page dashboardPage) {
+	servicesLock.Lock()
+	page.Instances = dashboardInstances()
+	servicesLock.Unlock()
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dashboardTemplate.Execute(writer, page)
+}
+
+// GET / shows the dashboard.
+func handleHTTPDashboard(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path != "/" {
+		http.NotFound(writer, request)
+		return
+	}
+	renderDashboard(writer, dashboardPage{})
+}
+
+// POST /call/{name} invokes a service with the arguments of the form
+// ("arg" fields in order) and shows the result on the dashboard.
+func handleHTTPCall(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		writeJSONError(writer, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := strings.TrimPrefix(request.URL.Path, "/call/")
+	page := dashboardPage{Call: name}
+
+	// only instances shown on the dashboard may be invoked
+	request.ParseForm()
+	known := false
+	servicesLock.Lock()
+	for _, instance := range dashboardInstances() {
+		known = known || (instance.Info.Name == name && instance.Address == request.PostForm.Get("address"))
+	}
+	servicesLock.Unlock()
+
+	address, err := net.ResolveTCPAddr(TCP_PROTOCOL, request.PostForm.Get("address"))
+	if err == nil && !known {
+		err = errors.New("error: unknown service instance")
+	}
+	if err == nil {
+		page.Result, err = CallServiceAddress(address, name, request.PostForm["arg"]...)
+	}
+	if err != nil {
+		page.Error = err.Error()
+	}
+
+	renderDashboard(writer, page)
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// Static registry address ("host:port"). If set, GetRegistryAddress()
+	// uses it instead of multicast discovery, which only works on the local
+	// network segment. Defaults to the environment variable HTW_REGISTRY.
+	REGISTRY_ADDRESS = os.Getenv("HTW_REGISTRY")
+	// TCP port of the registry server (0 = any free port). Federated
+	// registries need a fixed port, so that they can be used as peers.
+	REGISTRY_PORT = 0
+	// Addresses ("host:port") of peer registries, e.g. on other subnets.
+	// The registry periodically fetches their service catalogs and answers
+	// lookups for remote services.
+	REGISTRY_PEERS []string
+	// Interval in which the catalogs of the peer registries are fetched.
+	REGISTRY_SYNC_INTERVAL = 10 * time.Second
+	// Whether remote services are answered with the address of a local
+	// proxy instead of their own address (for peers which aren't routable
+	// from the local network).
+	REGISTRY_PROXY = false
+	// Operation for LookupInfoRequest: get the catalog of all services
+	// registered locally at the registry (used between peer registries).
+	OPERATION_CATALOG = "catalog"
+	// Catalogs of the peer registries. The mapping is from peer address
+	// to service name to instances. Guarded by servicesLock.
+	peerServices = make(map[string]map[string][]ServiceInfoAddress)
+	// Running proxies, mapping from remote service address to the
+	// listener of the local proxy. Guarded by servicesLock.
+	proxies = make(map[string]*net.TCPListener)
+	// Health of the remote instances behind the proxies, by remote
+	// address (see REGISTRY_HEALTH_INTERVAL). Guarded by servicesLock.
+	proxyHealth = make(map[string]InstanceHealth)
+)
+
+// Returns all known services, the mapping is from service name to
+// instances. Local instances come first (except drained or unhealthy
+// ones), followed by the instances of the peer registries (their Origin
+// is set to the peer address, proxied ones which aren't reachable from
+// here are left out). The localIP is the registry address seen
+// by the asking client, it's used for addresses of proxies. The caller
+// must hold servicesLock.
+func knownServices(localIP net.IP) map[string][]ServiceInfoAddress {
+	result := make(map[string][]ServiceInfoAddress)
+	for name, instances := range services {
+		for _, instance := range instances {
+			if instanceAvailable(instance) {
+				result[name] = append(result[name], instance)
+			}
+		}
+	}
+
+	for _, catalog := range peerServices {
+		for name, instances := range catalog {
+			for _, instance := range instances {
+				if REGISTRY_PROXY {
+					listener, ok := proxies[instance.Address]
+					health, checked := proxyHealth[instance.Address]
+					if !ok || (checked && !health.Healthy) {
+						continue
+					}
+					port := listener.Addr().(*net.TCPAddr).Port
+					instance.Address = net.JoinHostPort(localIP.String(), strconv.Itoa(port))
+				}
+				result[name] = append(result[name], instance)
+			}
+		}
+	}
+
+	return result
+}
+
+// Returns the catalog of all locally registered services for a peer
+// registry. Loopback addresses are replaced with the address under
+// which the peer reached us, so the peer can hand them out to its
+// clients. The caller must hold servicesLock.
+func localCatalog(localIP net.IP) map[string][]ServiceInfoAddress {
+	result := make(map[string][]ServiceInfoAddress)
+	for name, instances := range services {
+		for _, instance := range instances {
+			if !instanceAvailable(instance) {
+				continue
+			}
+			host, port, err := net.SplitHostPort(instance.Address)
+			if err == nil && net.ParseIP(host).IsLoopback() {
+				instance.Address = net.JoinHostPort(localIP.String(), port)
+			}
+			result[name] = append(result[name], instance)
+		}
+	}
+	return result
+}
+
+// Fetches the catalog of locally registered services from a peer registry.
+func fetchCatalog(peer string) (map[string][]ServiceInfoAddress, error) {
+	catalog := make(map[string][]ServiceInfoAddress)
+
+	connection, err := net.DialTimeout(TCP_PROTOCOL, peer, time.Second*4)
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+
+	connection.SetDeadline(time.Now().Add(time.Second * 4))
+	bytes, err := json.Marshal(LookupInfoRequest{OPERATION_CATALOG, "", ""})
+	if err != nil {
+		return nil, err
+	}
+	_, err = connection.Write(bytes)
+	if err != nil {
+		return nil, err
+	}
+	err = json.NewDecoder(connection).Decode(&catalog)
+	if err != nil {
+		return nil, err
+	}
+
+	return catalog, nil
+}
+
+// Periodically exchanges the service catalogs with all peer registries.
+// Peers which can't be reached are dropped until they answer again.
+func federationService() {
+	for {
+		for _, peer := range REGISTRY_PEERS {
+			catalog, err := fetchCatalog(peer)
+			if err != nil {
+				fmt.Println("peer registry unreachable:", peer, err)
+			}
+
+			servicesLock.Lock()
+			previous := peerServices[peer]
+			if err != nil {
+				delete(peerServices, peer)
+			} else {
+				for _, instances := range catalog {
+					for i := range instances {
+						instances[i].Origin = peer
+					}
+				}
+				peerServices[peer] = catalog
+			}
+			publishCatalogChange(previous, peerServices[peer])
+			if REGISTRY_PROXY {
+				updateProxies()
+			}
+			servicesLock.Unlock()
+		}
+		time.Sleep(REGISTRY_SYNC_INTERVAL)
+	}
+}
+
+// Starts a proxy for every instance of the peer registries which has none
+// yet and stops the proxies of instances which disappeared, so that their
+// ports are released. The caller must hold servicesLock.
+func updateProxies() {
+	remotes := make(map[string]bool)
+	for _, catalog := range peerServices {
+		for _, instances := range catalog {
+			for _, instance := range instances {
+				remotes[instance.Address] = true
+			}
+		}
+	}
+
+	for remote, listener := range proxies {
+		if !remotes[remote] {
+			listener.Close()
+			delete(proxies, remote)
+			delete(proxyHealth, remote)
+		}
+	}
+	for remote := range remotes {
+		if _, ok := proxies[remote]; ok {
+			continue
+		}
+		listener, err := startProxy(remote)
+		if err != nil {
+			fmt.Println("proxy failed:", remote, err)
+			continue
+		}
+		proxies[remote] = listener
+	}
+}
+
+// Starts a proxy which forwards connections to the given remote address.
+// The proxy stops when its listener is closed; connections which are
+// already forwarded are kept until either side closes them.
+func startProxy(remote string) (*net.TCPListener, error) {
+	listener, err := net.ListenTCP(TCP_PROTOCOL, TCP_ANY_ADDR)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			connection, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			go proxyConnection(connection, remote)
+		}
+	}()
+
+	return listener, nil
+}
+
+// Forwards all data between the connection and the remote address. The
+// end of the data in one direction is passed on as a half-close, so that
+// both sides can still finish the other direction; the connections are
+// closed when both directions ended.
+func proxyConnection(connection *net.TCPConn, remote string) {
+	defer connection.Close()
+
+	target, err := net.DialTimeout(TCP_PROTOCOL, remote, time.Second*4)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(target, connection)
+		target.(*net.TCPConn).CloseWrite()
+		close(done)
+	}()
+	io.Copy(connection, target)
+	connection.CloseWrite()
+	<-done
+}
+
+// Checks the health of the remote instances behind the proxies from here,
+// since the peer registry can only tell whether they are reachable from
+// its own network.
+func checkProxyHealth(slots chan struct{}) {
+	servicesLock.Lock()
+	previous := make(map[string]InstanceHealth)
+	for remote := range proxies {
+		previous[remote] = proxyHealth[remote]
+	}
+	servicesLock.Unlock()
+
+	var wait sync.WaitGroup
+	for remote := range previous {
+		wait.Add(1)
+		slots <- struct{}{}
+		go func(remote string) {
+			defer wait.Done()
+			defer func() { <-slots }()
+			health := checkInstanceHealth(remote, previous[remote])
+			servicesLock.Lock()
+			if _, ok := proxies[remote]; ok {
+				proxyHealth[remote] = health
+			}
+			servicesLock.Unlock()
+		}(remote)
+	}
+	wait.Wait()
+}
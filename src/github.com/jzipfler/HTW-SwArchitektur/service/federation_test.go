@@ -0,0 +1,84 @@
+package service
+
+import (
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestProxyHalfClose(t *testing.T) {
+	// the remote service answers after the client finished its request
+	remote, err := net.ListenTCP(TCP_PROTOCOL, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	go func() {
+		connection, err := remote.AcceptTCP()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+		request, _ := ioutil.ReadAll(connection)
+		connection.Write(append([]byte("reply to "), request...))
+	}()
+
+	proxy, err := startProxy(remote.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(proxy.Addr().(*net.TCPAddr).Port))
+	connection, err := net.Dial(TCP_PROTOCOL, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	connection.SetDeadline(time.Now().Add(2 * time.Second))
+
+	connection.Write([]byte("request"))
+	connection.(*net.TCPConn).CloseWrite()
+	reply, err := ioutil.ReadAll(connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "reply to request" {
+		t.Errorf("reply %q, want %q", reply, "reply to request")
+	}
+}
+
+func TestKnownServicesProxyHealth(t *testing.T) {
+	defer func(proxy bool) { REGISTRY_PROXY = proxy }(REGISTRY_PROXY)
+	REGISTRY_PROXY = true
+
+	servicesLock.Lock()
+	defer servicesLock.Unlock()
+	defer func() {
+		peerServices = make(map[string]map[string][]ServiceInfoAddress)
+		proxyHealth = make(map[string]InstanceHealth)
+		for _, listener := range proxies {
+			listener.Close()
+		}
+		proxies = make(map[string]*net.TCPListener)
+	}()
+
+	peerServices["10.0.2.5:32002"] = map[string][]ServiceInfoAddress{
+		"remote": {
+			{Info: ServiceInfo{Name: "remote"}, Address: "10.0.2.6:4000", Origin: "10.0.2.5:32002"},
+			{Info: ServiceInfo{Name: "remote"}, Address: "10.0.2.7:4000", Origin: "10.0.2.5:32002"},
+		},
+	}
+	updateProxies()
+	proxyHealth["10.0.2.7:4000"] = InstanceHealth{Healthy: false, Checked: time.Now()}
+
+	instances := knownServices(net.IPv4(127, 0, 0, 1))["remote"]
+	if len(instances) != 1 {
+		t.Fatalf("%d instances of remote, want 1", len(instances))
+	}
+	if instances[0].Address == "10.0.2.6:4000" {
+		t.Error("address of the remote instance wasn't replaced with the proxy")
+	}
+}
@@ -0,0 +1,479 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Discovery mode: services gossip their ServiceInfoAddress to each
+	// other (SWIM-style membership), no registry is needed.
+	DISCOVERY_GOSSIP = "gossip"
+	// Gossip addresses ("host:port") of members to join, defaults to the
+	// comma separated environment variable HTW_GOSSIP_SEEDS.
+	GOSSIP_SEEDS = splitList(os.Getenv("HTW_GOSSIP_SEEDS"))
+	// UDP port for gossip messages (0 = any free port), defaults to the
+	// environment variable HTW_GOSSIP_PORT.
+	GOSSIP_PORT, _ = strconv.Atoi(os.Getenv("HTW_GOSSIP_PORT"))
+	// Protocol period: each period one random member is probed.
+	GOSSIP_INTERVAL = time.Second
+	// Time to wait for an ack before members are asked to probe indirectly.
+	GOSSIP_ACK_TIMEOUT = 300 * time.Millisecond
+	// Number of members asked to probe a member indirectly.
+	GOSSIP_INDIRECT_PROBES = 3
+	// Time after which a suspected member is declared dead.
+	GOSSIP_SUSPECT_TIMEOUT = 5 * time.Second
+	// Time after which the services of dead members are forgotten. The
+	// member itself is kept as tombstone, so that stale gossip doesn't
+	// resurrect it.
+	GOSSIP_DEAD_TIMEOUT = 30 * time.Second
+	// Time after which tombstones of dead members are forgotten.
+	GOSSIP_TOMBSTONE_TIMEOUT = 10 * time.Minute
+	// Maximum size of a gossip message in bytes. Messages carry as many
+	// members as fit, recently changed members first.
+	GOSSIP_PACKET_SIZE = 1400
+	// Time to wait for the first answer of a seed when joining.
+	GOSSIP_JOIN_TIMEOUT = 2 * time.Second
+	// The gossip node of this process, started on first use.
+	gossip     *gossipNode
+	gossipLock sync.Mutex
+)
+
+const (
+	memberAlive   = "alive"
+	memberSuspect = "suspect"
+	memberDead    = "dead"
+)
+
+// State of a member as it's gossiped. Only the member itself changes its
+// services and increments the incarnation, e.g. to refute a suspicion.
+type gossipMember struct {
+	Address     string
+	Incarnation uint64
+	State       string
+	Services    []ServiceInfoAddress `json:",omitempty"`
+	// time of the last state change, and of the last accepted update
+	changed time.Time
+	updated time.Time
+}
+
+// Message between gossip nodes ("ping", "ack" or "ping-req"). Every
+// message carries the sender and as many other members of its membership
+// list as fit into GOSSIP_PACKET_SIZE.
+type gossipMessage struct {
+	Type    string
+	Seq     uint64
+	From    string
+	Target  string
+	Members []gossipMember
+}
+
+// A member of the gossip cluster (this process).
+type gossipNode struct {
+	lock       sync.Mutex
+	connection *net.UDPConn
+	self       string
+	members    map[string]*gossipMember
+	seq        uint64
+	// pending acks by sequence number
+	acks map[uint64]chan bool
+	// forwarded indirect probes, sequence number to requester message
+	forwards map[uint64]gossipMessage
+	joined   chan bool
+}
+
+// Splits a comma separated list, ignoring empty entries.
+func splitList(value string) []string {
+	result := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// Returns the IP under which this host is reachable by the seeds.
+func gossipAdvertiseIP() net.IP {
+	for _, seed := range GOSSIP_SEEDS {
+		connection, err := net.Dial(UDP_PROTOCOL, seed)
+		if err == nil {
+			defer connection.Close()
+			return connection.LocalAddr().(*net.UDPAddr).IP
+		}
+	}
+
+	intf, _ := net.Interfaces()
+	for _, i := range intf {
+		if ip := interfaceIPv4(i); ip != nil && !ip.IsLoopback() {
+			return ip
+		}
+	}
+	return net.ParseIP("127.0.0.1")
+}
+
+// Returns the gossip node of this process and starts it if necessary.
+// When started, the node joins the GOSSIP_SEEDS and waits until one
+// of them answered (or GOSSIP_JOIN_TIMEOUT passed).
+func getGossipNode() (*gossipNode, error) {
+	gossipLock.Lock()
+	defer gossipLock.Unlock()
+
+	if gossip != nil {
+		return gossip, nil
+	}
+
+	connection, err := net.ListenUDP(UDP_PROTOCOL, &net.UDPAddr{IP: UDP_ANY_ADDR.IP, Port: GOSSIP_PORT})
+	if err != nil {
+		return nil, err
+	}
+	port := connection.LocalAddr().(*net.UDPAddr).Port
+	self := net.JoinHostPort(gossipAdvertiseIP().String(), strconv.Itoa(port))
+
+	node := &gossipNode{
+		connection: connection,
+		self:       self,
+		members:    make(map[string]*gossipMember),
+		acks:       make(map[uint64]chan bool),
+		forwards:   make(map[uint64]gossipMessage),
+		joined:     make(chan bool, 1),
+	}
+	node.members[self] = &gossipMember{Address: self, State: memberAlive, changed: time.Now(), updated: time.Now()}
+	go node.receive()
+	go node.probe()
+
+	if len(GOSSIP_SEEDS) > 0 {
+		for _, seed := range GOSSIP_SEEDS {
+			node.send(seed, gossipMessage{Type: "ping", Seq: node.nextSeq()})
+		}
+		select {
+		case <-node.joined:
+		case <-time.After(GOSSIP_JOIN_TIMEOUT):
+			fmt.Println("gossip: no seed answered")
+		}
+	}
+
+	gossip = node
+	return node, nil
+}
+
+// Returns a new sequence number.
+func (node *gossipNode) nextSeq() uint64 {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	node.seq++
+	return node.seq
+}
+
+// Sends a message with the membership list to the address. The list
+// starts with this node, followed by recently updated members and then
+// random other members, as many as fit into GOSSIP_PACKET_SIZE. Since the
+// other members are picked randomly, every member is gossiped eventually.
+func (node *gossipNode) send(address string, message gossipMessage) {
+	message.From = node.self
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	size := len(bytes)
+
+	node.lock.Lock()
+	recent, others := []*gossipMember{}, []*gossipMember{}
+	for _, member := range node.members {
+		switch {
+		case member.Address == node.self:
+		case time.Since(member.updated) < GOSSIP_SUSPECT_TIMEOUT:
+			recent = append(recent, member)
+		default:
+			others = append(others, member)
+		}
+	}
+	rand.Shuffle(len(recent), func(i, j int) { recent[i], recent[j] = recent[j], recent[i] })
+	rand.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+	for i, member := range append(append([]*gossipMember{node.members[node.self]}, recent...), others...) {
+		encoded, err := json.Marshal(member)
+		if err != nil || (i > 0 && size+len(encoded)+1 > GOSSIP_PACKET_SIZE) {
+			continue
+		}
+		message.Members = append(message.Members, *member)
+		size += len(encoded) + 1
+	}
+	node.lock.Unlock()
+
+	destination, err := net.ResolveUDPAddr(UDP_PROTOCOL, address)
+	if err != nil {
+		return
+	}
+	bytes, err = json.Marshal(message)
+	if err != nil {
+		return
+	}
+	node.connection.WriteToUDP(bytes, destination)
+}
+
+// Adds a service of this process, which is then gossiped to all members.
+func (node *gossipNode) addService(instance ServiceInfoAddress) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+
+	self := node.members[node.self]
+	self.Services = append(self.Services, instance)
+	self.Incarnation++
+	self.updated = time.Now()
+}
+
+// Merges a gossiped member state into the membership list. Newer
+// incarnations win, for the same incarnation dead overrides suspect
+// and suspect overrides alive. Suspicions about this node are refuted
+// by incrementing its incarnation. Tombstones of dead members only accept
+// newer incarnations, e.g. of a restarted member which refuted its death.
+// The caller must hold node.lock.
+func (node *gossipNode) merge(update gossipMember) {
+	rank := map[string]int{memberAlive: 0, memberSuspect: 1, memberDead: 2}
+
+	if update.Address == node.self {
+		self := node.members[node.self]
+		if update.State != memberAlive && update.Incarnation >= self.Incarnation {
+			self.Incarnation = update.Incarnation + 1
+			self.updated = time.Now()
+		}
+		return
+	}
+
+	member, ok := node.members[update.Address]
+	if !ok {
+		if update.State != memberDead {
+			update.changed = time.Now()
+			update.updated = update.changed
+			node.members[update.Address] = &update
+			fmt.Println("gossip: member joined:", update.Address)
+		}
+		return
+	}
+
+	if update.Incarnation > member.Incarnation ||
+		(update.Incarnation == member.Incarnation && rank[update.State] > rank[member.State]) {
+		if update.State != member.State {
+			fmt.Println("gossip: member "+update.State+":", update.Address)
+			member.changed = time.Now()
+		}
+		member.Incarnation = update.Incarnation
+		member.State = update.State
+		member.Services = update.Services
+		member.updated = time.Now()
+	}
+}
+
+// Marks a member as alive, because it answered directly. The caller
+// must hold node.lock.
+func (node *gossipNode) markAlive(address string) {
+	if member, ok := node.members[address]; ok && member.State == memberSuspect {
+		// the member refutes the suspicion itself, but until that
+		// arrives the direct answer is good enough
+		member.State = memberAlive
+		member.changed = time.Now()
+		member.updated = member.changed
+	}
+}
+
+// Receives and handles all gossip messages.
+func (node *gossipNode) receive() {
+	buffer := make([]byte, PACKET_SIZE)
+
+	for {
+		length, _, err := node.connection.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		message := gossipMessage{}
+		if json.Unmarshal(buffer[:length], &message) != nil {
+			continue
+		}
+
+		node.lock.Lock()
+		for _, member := range message.Members {
+			node.merge(member)
+		}
+		node.markAlive(message.From)
+		ack, pending := node.acks[message.Seq]
+		forward, forwarded := node.forwards[message.Seq]
+		if message.Type == "ack" {
+			delete(node.acks, message.Seq)
+			delete(node.forwards, message.Seq)
+		}
+		node.lock.Unlock()
+
+		switch message.Type {
+		case "ping":
+			node.send(message.From, gossipMessage{Type: "ack", Seq: message.Seq})
+		case "ping-req":
+			seq := node.nextSeq()
+			node.lock.Lock()
+			node.forwards[seq] = message
+			node.lock.Unlock()
+			// the requester gives up after the protocol period
+			time.AfterFunc(GOSSIP_INTERVAL, func() {
+				node.lock.Lock()
+				delete(node.forwards, seq)
+				node.lock.Unlock()
+			})
+			node.send(message.Target, gossipMessage{Type: "ping", Seq: seq})
+		case "ack":
+			if pending {
+				ack <- true
+			}
+			if forwarded {
+				node.send(forward.From, gossipMessage{Type: "ack", Seq: forward.Seq})
+			}
+			select {
+			case node.joined <- true:
+			default:
+			}
+		}
+	}
+}
+
+// Returns the addresses of all members which aren't dead, except this one.
+func (node *gossipNode) otherMembers() []string {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+
+	result := []string{}
+	for address, member := range node.members {
+		if address != node.self && member.State != memberDead {
+			result = append(result, address)
+		}
+	}
+	return result
+}
+
+// Probes a random member each protocol period. If it doesn't answer in
+// time, other members are asked to probe it indirectly; if that fails
+// too, the member is suspected and later declared dead.
+func (node *gossipNode) probe() {
+	for {
+		time.Sleep(GOSSIP_INTERVAL)
+		node.expire()
+
+		others := node.otherMembers()
+		if len(others) == 0 {
+			continue
+		}
+		target := others[rand.Intn(len(others))]
+
+		seq := node.nextSeq()
+		ack := make(chan bool, 1)
+		node.lock.Lock()
+		node.acks[seq] = ack
+		node.lock.Unlock()
+
+		node.send(target, gossipMessage{Type: "ping", Seq: seq})
+		select {
+		case <-ack:
+			continue
+		case <-time.After(GOSSIP_ACK_TIMEOUT):
+		}
+
+		for i, index := range rand.Perm(len(others)) {
+			if i >= GOSSIP_INDIRECT_PROBES {
+				break
+			}
+			if others[index] != target {
+				node.send(others[index], gossipMessage{Type: "ping-req", Seq: seq, Target: target})
+			}
+		}
+		select {
+		case <-ack:
+			continue
+		case <-time.After(GOSSIP_INTERVAL - GOSSIP_ACK_TIMEOUT):
+		}
+
+		node.lock.Lock()
+		delete(node.acks, seq)
+		if member, ok := node.members[target]; ok && member.State == memberAlive {
+			fmt.Println("gossip: member suspect:", target)
+			member.State = memberSuspect
+			member.changed = time.Now()
+			member.updated = member.changed
+		}
+		node.lock.Unlock()
+	}
+}
+
+// Declares suspected members dead, turns dead members into tombstones
+// (without services) and forgets tombstones after their timeouts.
+func (node *gossipNode) expire() {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+
+	for address, member := range node.members {
+		switch {
+		case member.State == memberSuspect && time.Since(member.changed) > GOSSIP_SUSPECT_TIMEOUT:
+			fmt.Println("gossip: member dead:", address)
+			member.State = memberDead
+			member.changed = time.Now()
+			member.updated = member.changed
+		case member.State == memberDead && time.Since(member.changed) > GOSSIP_TOMBSTONE_TIMEOUT:
+			delete(node.members, address)
+		case member.State == memberDead && time.Since(member.changed) > GOSSIP_DEAD_TIMEOUT:
+			member.Services = nil
+		}
+	}
+}
+
+// Returns all services known from the gossip view. The mapping is from
+// canonical service name to instances of members which aren't dead.
+func (node *gossipNode) knownServices() map[string][]ServiceInfoAddress {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+
+	result := make(map[string][]ServiceInfoAddress)
+	for _, member := range node.members {
+		if member.State == memberDead {
+			continue
+		}
+		for _, instance := range member.Services {
+			name := CanonicalServiceName(instance.Info.Name)
+			result[name] = append(result[name], instance)
+		}
+	}
+	return result
+}
+
+// Gossips the service listening on the given port to all members.
+func publishServiceGossip(serviceinfo *ServiceInfo, port int) error {
+	node, err := getGossipNode()
+	if err != nil {
+		return err
+	}
+
+	host, _, _ := net.SplitHostPort(node.self)
+	node.addService(ServiceInfoAddress{Address: net.JoinHostPort(host, strconv.Itoa(port)), Info: *serviceinfo})
+	return nil
+}
+
+// Answers a lookup request from the local gossip view.
+func lookupGossip(request *LookupInfoRequest) ([]byte, error) {
+	node, err := getGossipNode()
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Operation != OPERATION_LIST {
+		request.ServiceName = CanonicalServiceName(request.ServiceName)
+	}
+	bytes, ok := lookupResponse(request, node.knownServices())
+	if !ok {
+		return nil, errors.New("error: operation \"" + request.Operation + "\" is not supported via gossip")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import "testing"
+
+func TestGossipMerge(t *testing.T) {
+	services := []ServiceInfoAddress{{Address: "10.0.0.2:4000", Info: ServiceInfo{Name: "isprime"}}}
+	tests := []struct {
+		name   string
+		member gossipMember
+		update gossipMember
+		state  string
+		inc    uint64
+	}{
+		{"newer incarnation", gossipMember{Incarnation: 1, State: memberSuspect}, gossipMember{Incarnation: 2, State: memberAlive}, memberAlive, 2},
+		{"older incarnation", gossipMember{Incarnation: 2, State: memberAlive}, gossipMember{Incarnation: 1, State: memberDead}, memberAlive, 2},
+		{"suspect overrides alive", gossipMember{Incarnation: 1, State: memberAlive}, gossipMember{Incarnation: 1, State: memberSuspect}, memberSuspect, 1},
+		{"alive doesn't override suspect", gossipMember{Incarnation: 1, State: memberSuspect}, gossipMember{Incarnation: 1, State: memberAlive}, memberSuspect, 1},
+		{"stale gossip doesn't resurrect tombstone", gossipMember{Incarnation: 3, State: memberDead}, gossipMember{Incarnation: 3, State: memberAlive, Services: services}, memberDead, 3},
+		{"restarted member replaces tombstone", gossipMember{Incarnation: 3, State: memberDead}, gossipMember{Incarnation: 4, State: memberAlive, Services: services}, memberAlive, 4},
+	}
+
+	for _, test := range tests {
+		node := &gossipNode{self: "10.0.0.1:33001", members: make(map[string]*gossipMember)}
+		node.members[node.self] = &gossipMember{Address: node.self, State: memberAlive}
+		test.member.Address, test.update.Address = "10.0.0.2:33001", "10.0.0.2:33001"
+		member := test.member
+		node.members[member.Address] = &member
+
+		node.merge(test.update)
+		if member.State != test.state || member.Incarnation != test.inc {
+			t.Errorf("%s: merge() = %s/%d, want %s/%d", test.name, member.State, member.Incarnation, test.state, test.inc)
+		}
+	}
+}
+
+func TestGossipMergeSelf(t *testing.T) {
+	node := &gossipNode{self: "10.0.0.1:33001", members: make(map[string]*gossipMember)}
+	node.members[node.self] = &gossipMember{Address: node.self, State: memberAlive}
+
+	// a restarted node learns about its own tombstone and refutes it
+	node.merge(gossipMember{Address: node.self, Incarnation: 5, State: memberDead})
+	if self := node.members[node.self]; self.State != memberAlive || self.Incarnation != 6 {
+		t.Errorf("merge() of own tombstone = %s/%d, want %s/6", self.State, self.Incarnation, memberAlive)
+	}
+
+	// unknown dead members aren't added
+	node.merge(gossipMember{Address: "10.0.0.3:33001", State: memberDead})
+	if _, ok := node.members["10.0.0.3:33001"]; ok {
+		t.Error("merge() added an unknown dead member")
+	}
+}
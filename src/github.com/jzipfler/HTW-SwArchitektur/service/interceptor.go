@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+// Invokes a service call on the client: the next interceptor of the chain
+// or, at its end, the actual call (including lookup and retries).
+type ClientInvoker func(ctx context.Context, servicecall *ServiceCall) (string, error)
+
+// Wraps service calls on the client, e.g. for logging, metrics or adding
+// Metadata. It may modify the call, return without calling next, or
+// process the result of next.
+type ClientInterceptor func(ctx context.Context, servicecall *ServiceCall, next ClientInvoker) (string, error)
+
+// Wraps the handler of a service, e.g. for logging, metrics or checking
+// Metadata. It may modify the call, return without calling next, or
+// process the result of next.
+type ServerInterceptor func(info *ServiceInfo, servicecall *ServiceCall, next ServiceHandler) string
+
+var (
+	// Interceptors of the client and of services, in the order they were
+	// added.
+	clientInterceptors []ClientInterceptor
+	serverInterceptors []ServerInterceptor
+	interceptorsLock   sync.Mutex
+)
+
+// Adds an interceptor to the client chain, which wraps every call of
+// CallService() and its variants. Interceptors are invoked in the order
+// they were added: the first one sees the call first and the result last.
+func AddClientInterceptor(interceptor ClientInterceptor) {
+	interceptorsLock.Lock()
+	defer interceptorsLock.Unlock()
+	clientInterceptors = append(clientInterceptors, interceptor)
+}
+
+// Adds an interceptor to the server chain, which wraps the handlers of
+// services started by RunService() afterwards. Interceptors are invoked in
+// the order they were added: the first one sees the call first and the
+// result last. Health checks (HEALTH_CHECK_CALL) aren't intercepted.
+func AddServerInterceptor(interceptor ServerInterceptor) {
+	interceptorsLock.Lock()
+	defer interceptorsLock.Unlock()
+	serverInterceptors = append(serverInterceptors, interceptor)
+}
+
+// Invokes a call through the client chain, ending with the given invoker.
+func interceptCall(ctx context.Context, servicecall *ServiceCall, invoker ClientInvoker) (string, error) {
+	interceptorsLock.Lock()
+	chain := clientInterceptors
+	interceptorsLock.Unlock()
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		interceptor, next := chain[i], invoker
+		invoker = func(ctx context.Context, servicecall *ServiceCall) (string, error) {
+			return interceptor(ctx, servicecall, next)
+		}
+	}
+	return invoker(ctx, servicecall)
+}
+
+// Returns the handler of a service wrapped by the server chain.
+func interceptHandler(info *ServiceInfo, handler ServiceHandler) ServiceHandler {
+	interceptorsLock.Lock()
+	chain := serverInterceptors
+	interceptorsLock.Unlock()
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		interceptor, next := chain[i], handler
+		handler = func(servicecall *ServiceCall) string {
+			return interceptor(info, servicecall, next)
+		}
+	}
+	return handler
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Removes all interceptors after a test.
+func resetInterceptors() {
+	interceptorsLock.Lock()
+	clientInterceptors, serverInterceptors = nil, nil
+	interceptorsLock.Unlock()
+}
+
+func TestClientInterceptorOrder(t *testing.T) {
+	defer resetInterceptors()
+	trace := []string{}
+	for _, name := range []string{"first", "second"} {
+		name := name
+		AddClientInterceptor(func(ctx context.Context, servicecall *ServiceCall, next ClientInvoker) (string, error) {
+			trace = append(trace, name+" call")
+			servicecall.Arguments = append(servicecall.Arguments, name)
+			result, err := next(ctx, servicecall)
+			trace = append(trace, name+" result")
+			return result + " " + name, err
+		})
+	}
+
+	result, err := interceptCall(context.Background(), &ServiceCall{Name: "echo"}, func(ctx context.Context, servicecall *ServiceCall) (string, error) {
+		trace = append(trace, "invoker")
+		return strings.Join(servicecall.Arguments, ","), nil
+	})
+	if err != nil || result != "first,second second first" {
+		t.Errorf("interceptCall() = %q, %v, want %q", result, err, "first,second second first")
+	}
+	if want := []string{"first call", "second call", "invoker", "second result", "first result"}; !reflect.DeepEqual(trace, want) {
+		t.Errorf("order %v, want %v", trace, want)
+	}
+}
+
+func TestClientInterceptorShortCircuit(t *testing.T) {
+	defer resetInterceptors()
+	rejected := errors.New("error: rejected")
+	AddClientInterceptor(func(ctx context.Context, servicecall *ServiceCall, next ClientInvoker) (string, error) {
+		if servicecall.Name == "forbidden" {
+			return "", rejected
+		}
+		return next(ctx, servicecall)
+	})
+
+	invoked := false
+	invoker := func(ctx context.Context, servicecall *ServiceCall) (string, error) {
+		invoked = true
+		return "ok", nil
+	}
+	if _, err := interceptCall(context.Background(), &ServiceCall{Name: "forbidden"}, invoker); err != rejected || invoked {
+		t.Errorf("interceptCall() = %v, invoked %t, want the error of the interceptor", err, invoked)
+	}
+	if result, err := interceptCall(context.Background(), &ServiceCall{Name: "allowed"}, invoker); err != nil || result != "ok" {
+		t.Errorf("interceptCall() = %q, %v, want ok", result, err)
+	}
+}
+
+func TestServerInterceptorOrder(t *testing.T) {
+	defer resetInterceptors()
+	info := &ServiceInfo{Name: "echo"}
+	trace := []string{}
+	for _, name := range []string{"first", "second"} {
+		name := name
+		AddServerInterceptor(func(serviceinfo *ServiceInfo, servicecall *ServiceCall, next ServiceHandler) string {
+			if serviceinfo != info {
+				t.Error("interceptor received another ServiceInfo")
+			}
+			trace = append(trace, name)
+			return next(servicecall) + " " + name
+		})
+	}
+	handler := interceptHandler(info, func(servicecall *ServiceCall) string {
+		trace = append(trace, "handler")
+		return servicecall.Name
+	})
+
+	// interceptors added later don't wrap the handler
+	AddServerInterceptor(func(serviceinfo *ServiceInfo, servicecall *ServiceCall, next ServiceHandler) string {
+		t.Error("interceptor added after the handler was wrapped was invoked")
+		return next(servicecall)
+	})
+
+	if result := handler(&ServiceCall{Name: "echo"}); result != "echo second first" {
+		t.Errorf("handler() = %q, want %q", result, "echo second first")
+	}
+	if want := []string{"first", "second", "handler"}; !reflect.DeepEqual(trace, want) {
+		t.Errorf("order %v, want %v", trace, want)
+	}
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// State of a job.
+type JobState string
+
+const (
+	// The handler is running.
+	JOB_RUNNING JobState = "running"
+	// The handler returned a result.
+	JOB_DONE JobState = "done"
+	// The handler failed (e.g. it panicked).
+	JOB_FAILED JobState = "failed"
+	// The job was canceled; its result is discarded.
+	JOB_CANCELED JobState = "canceled"
+)
+
+// Status of a job, which is the JSON result of JOB_STATUS_CALL.
+type JobStatus struct {
+	ID       string
+	State    JobState
+	Result   string        `json:",omitempty"`
+	Error    *ServiceError `json:",omitempty"`
+	Started  time.Time
+	Finished time.Time
+}
+
+// Returns true if the job is finished, so its status won't change anymore.
+func (status *JobStatus) Done() bool {
+	return status.State != JOB_RUNNING
+}
+
+var (
+	// Name of the call which every service answers with the JobStatus of
+	// the job whose ID is the argument.
+	JOB_STATUS_CALL = "_job.status"
+	// Name of the call which cancels the job whose ID is the argument. It's
+	// answered with the JobStatus.
+	JOB_CANCEL_CALL = "_job.cancel"
+	// Maximum number of running jobs per service. Further jobs are rejected
+	// with SERVICE_OVERLOADED. Canceled jobs count until their handler
+	// returns, so handlers should stop when ServiceCall.Context() is done.
+	MAX_JOBS = 16
+	// Duration finished jobs are kept, so that their result can be fetched.
+	JOB_RETENTION = time.Hour
+	// Maximum number of finished jobs kept per service. If there are more,
+	// the oldest ones are dropped before JOB_RETENTION.
+	MAX_RETAINED_JOBS = 1000
+	// Interval in which Job.Wait() polls the status of the job.
+	JOB_POLL_INTERVAL = time.Second
+	// Error of Job.Result() while the job is running.
+	ErrJobRunning = errors.New("error: job is still running")
+)
+
+const (
+	// There is no job with the given ID (anymore, see JOB_RETENTION).
+	SERVICE_NOT_FOUND = "not_found"
+)
+
+// A job of a service, started by StartJob().
+type serviceJob struct {
+	status JobStatus
+	cancel context.CancelFunc
+}
+
+// Jobs of a service by ID.
+type jobTable struct {
+	lock    sync.Mutex
+	jobs    map[string]*serviceJob
+	running int
+	// number of running handlers of canceled jobs
+	canceled int
+}
+
+// Returns a random job ID.
+func newJobID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Starts the handler for a call in job mode in the background and returns
+// the ID of the job. The job is subject to the rate limits, but not to
+// MAX_CONCURRENT_CALLS, since it would block a slot for a long time.
+func (runtime *serviceRuntime) startJob(servicecall *ServiceCall) ServiceResult {
+	err := checkRateLimits(runtime.info, servicecall)
+	if err != nil {
+		atomic.AddUint64(&runtime.stats.Rejected, 1)
+		return ServiceResult{Error: err}
+	}
+
+	table := &runtime.jobs
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	if table.running >= MAX_JOBS {
+		atomic.AddUint64(&runtime.stats.Rejected, 1)
+		return ServiceResult{Error: &ServiceError{SERVICE_OVERLOADED,
+			fmt.Sprintf("%d running jobs (%d of them canceled, but their handlers haven't returned yet)", table.running, table.canceled)}}
+	}
+	table.sweep()
+	if table.jobs == nil {
+		table.jobs = make(map[string]*serviceJob)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &serviceJob{JobStatus{ID: newJobID(), State: JOB_RUNNING, Started: time.Now()}, cancel}
+	table.jobs[job.status.ID] = job
+	table.running++
+
+	call := *servicecall
+	call.ctx = ctx
+	go runtime.runJob(job, &call)
+	return ServiceResult{Result: job.status.ID}
+}
+
+// Drops finished jobs older than JOB_RETENTION and, if there are more
+// than MAX_RETAINED_JOBS finished jobs, the oldest ones. The caller must
+// hold the lock of the table.
+func (table *jobTable) sweep() {
+	finished := []*serviceJob{}
+	for id, job := range table.jobs {
+		if !job.status.Done() {
+			continue
+		}
+		if time.Since(job.status.Finished) > JOB_RETENTION {
+			delete(table.jobs, id)
+			continue
+		}
+		finished = append(finished, job)
+	}
+	if len(finished) < MAX_RETAINED_JOBS {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].status.Finished.Before(finished[j].status.Finished)
+	})
+	// keep room for the job which is about to finish
+	for _, job := range finished[:len(finished)-MAX_RETAINED_JOBS+1] {
+		delete(table.jobs, job.status.ID)
+	}
+}
+
+// Runs the handler of a job, stores its result and calls the callback
+// service of the job, if any.
+func (runtime *serviceRuntime) runJob(job *serviceJob, servicecall *ServiceCall) {
+	atomic.AddUint64(&runtime.stats.Calls, 1)
+	atomic.AddInt64(&runtime.stats.Active, 1)
+	result := runtime.invoke(servicecall)
+	atomic.AddInt64(&runtime.stats.Active, -1)
+
+	table := &runtime.jobs
+	table.lock.Lock()
+	if job.status.State == JOB_RUNNING {
+		job.status.Finished = time.Now()
+		job.status.Result, job.status.Error = result.Result, result.Error
+		job.status.State = JOB_DONE
+		if result.Error != nil {
+			job.status.State = JOB_FAILED
+		}
+	} else if job.status.State == JOB_CANCELED {
+		table.canceled--
+	}
+	table.running--
+	status := job.status
+	table.lock.Unlock()
+	job.cancel()
+
+	if servicecall.Callback != "" {
+		message := ""
+		if status.Error != nil {
+			message = status.Error.Error()
+		}
+		_, err := CallService(servicecall.Callback, status.ID, string(status.State), status.Result, message)
+		if err != nil {
+			fmt.Println("job callback failed:", servicecall.Callback, err)
+		}
+	}
+}
+
+// Answers JOB_STATUS_CALL and JOB_CANCEL_CALL.
+func (runtime *serviceRuntime) jobCall(servicecall *ServiceCall) ServiceResult {
+	if len(servicecall.Arguments) != 1 {
+		return ServiceResult{Error: &ServiceError{SERVICE_NOT_FOUND, "missing job ID"}}
+	}
+
+	table := &runtime.jobs
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	job, ok := table.jobs[servicecall.Arguments[0]]
+	if !ok {
+		return ServiceResult{Error: &ServiceError{SERVICE_NOT_FOUND, "no job " + servicecall.Arguments[0]}}
+	}
+	if servicecall.Name == JOB_CANCEL_CALL && job.status.State == JOB_RUNNING {
+		job.status.State = JOB_CANCELED
+		job.status.Finished = time.Now()
+		job.cancel()
+		table.canceled++
+	}
+	result, _ := json.Marshal(job.status)
+	return ServiceResult{Result: string(result)}
+}
+
+// A job started by StartJob(). Its fields identify the job, so it can be
+// stored and restored, e.g. by another client.
+type Job struct {
+	ID      string
+	Service string
+	// Address of the instance which runs the job.
+	Address string
+}
+
+// Starts the highest version of the service specified by name which
+// satisfies the version constraint with the given arguments as job: the
+// service answers immediately with the ID of the job and runs the handler
+// in the background. Handlers can check ServiceCall.Context() to stop when
+// the job is canceled.
+func StartJob(ctx context.Context, name, constraint string, args ...string) (*Job, error) {
+	return StartJobCallback(ctx, "", name, constraint, args...)
+}
+
+// Same as StartJob(), but when the job is finished, the service calls the
+// callback service with the arguments job ID, state, result and error
+// message. Services which don't support jobs answer with the result of
+// the handler instead of a job ID; an error with SERVICE_UNSUPPORTED is
+// returned then (the handler has already run).
+func StartJobCallback(ctx context.Context, callback, name, constraint string, args ...string) (*Job, error) {
+	job := &Job{Service: name}
+	invoker := func(ctx context.Context, servicecall *ServiceCall) (string, error) {
+		instance, err := callInstance(name, constraint, nil)
+		if err != nil {
+			return "", err
+		}
+		job.Address = instance.Address
+		return callAttempt(ctx, &NO_RETRY, instance, servicecall)
+	}
+
+	id, err := interceptCall(ctx, &ServiceCall{Name: name, Arguments: args, Job: true, Callback: callback}, invoker)
+	if err != nil {
+		return nil, err
+	}
+	if !validJobID(id) {
+		return nil, &ServiceError{SERVICE_UNSUPPORTED, "service " + name + " doesn't support jobs"}
+	}
+	job.ID = id
+	return job, nil
+}
+
+// Returns true if the ID has the format of newJobID().
+func validJobID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return err == nil && len(id) == 32
+}
+
+// Calls JOB_STATUS_CALL or JOB_CANCEL_CALL of the job.
+func (job *Job) call(ctx context.Context, name string) (*JobStatus, error) {
+	address, err := net.ResolveTCPAddr(TCP_PROTOCOL, job.Address)
+	if err != nil {
+		return nil, err
+	}
+	result, err := CallServiceAddressContext(ctx, address, name, job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := JobStatus{}
+	err = json.Unmarshal([]byte(result), &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Returns the status of the job.
+func (job *Job) Status(ctx context.Context) (*JobStatus, error) {
+	return job.call(ctx, JOB_STATUS_CALL)
+}
+
+// Returns the result of the job, the error of the job if it failed or
+// was canceled, or ErrJobRunning if it's still running.
+func (job *Job) Result(ctx context.Context) (string, error) {
+	status, err := job.Status(ctx)
+	if err != nil {
+		return "", err
+	}
+	return status.result()
+}
+
+// Polls the status of the job every JOB_POLL_INTERVAL until it's finished
+// or the context is done, and returns its result like Result().
+func (job *Job) Wait(ctx context.Context) (string, error) {
+	for {
+		status, err := job.Status(ctx)
+		if err != nil {
+			return "", err
+		}
+		if status.Done() {
+			return status.result()
+		}
+
+		select {
+		case <-time.After(JOB_POLL_INTERVAL):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// Cancels the job. The result of the handler is discarded.
+func (job *Job) Cancel(ctx context.Context) error {
+	_, err := job.call(ctx, JOB_CANCEL_CALL)
+	return err
+}
+
+// Returns the result or the error of a job.
+func (status *JobStatus) result() (string, error) {
+	switch status.State {
+	case JOB_RUNNING:
+		return "", ErrJobRunning
+	case JOB_CANCELED:
+		return "", errors.New("error: job " + status.ID + " was canceled")
+	case JOB_FAILED:
+		return "", status.Error
+	}
+	return status.Result, nil
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestValidJobID(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{newJobID(), true},
+		{"0123456789abcdef0123456789abcdef", true},
+		{"", false},
+		{"true", false},
+		{"0123456789abcdef", false},
+		{"0123456789abcdef0123456789abcdeg", false},
+	}
+
+	for _, test := range tests {
+		if valid := validJobID(test.id); valid != test.valid {
+			t.Errorf("validJobID(%q) = %v, want %v", test.id, valid, test.valid)
+		}
+	}
+}
+
+func TestJobTableSweep(t *testing.T) {
+	defer func(retained int) { MAX_RETAINED_JOBS = retained }(MAX_RETAINED_JOBS)
+	MAX_RETAINED_JOBS = 3
+
+	now := time.Now()
+	table := jobTable{jobs: make(map[string]*serviceJob)}
+	add := func(id string, state JobState, finished time.Time) {
+		table.jobs[id] = &serviceJob{status: JobStatus{ID: id, State: state, Finished: finished}}
+	}
+	add("expired", JOB_DONE, now.Add(-JOB_RETENTION-time.Minute))
+	add("running", JOB_RUNNING, time.Time{})
+	for i := 1; i <= 4; i++ {
+		add(fmt.Sprint("done", i), JOB_DONE, now.Add(time.Duration(i)*time.Second))
+	}
+
+	table.sweep()
+	for _, id := range []string{"expired", "done1", "done2"} {
+		if _, ok := table.jobs[id]; ok {
+			t.Errorf("sweep() kept job %s", id)
+		}
+	}
+	for _, id := range []string{"running", "done3", "done4"} {
+		if _, ok := table.jobs[id]; !ok {
+			t.Errorf("sweep() dropped job %s", id)
+		}
+	}
+}
+
+func TestCanceledJobsCount(t *testing.T) {
+	defer func(jobs int) { MAX_JOBS = jobs }(MAX_JOBS)
+	MAX_JOBS = 1
+
+	release := make(chan struct{})
+	// the handler ignores the context of the job
+	handler := func(servicecall *ServiceCall) string {
+		<-release
+		return "done"
+	}
+	runtime := &serviceRuntime{info: &ServiceInfo{Name: "slow"}, handler: handler}
+
+	started := runtime.call(&ServiceCall{Name: "slow", Job: true})
+	if started.Error != nil {
+		t.Fatal(started.Error)
+	}
+	canceled := runtime.call(&ServiceCall{Name: JOB_CANCEL_CALL, Arguments: []string{started.Result}})
+	status := JobStatus{}
+	if canceled.Error != nil || json.Unmarshal([]byte(canceled.Result), &status) != nil || status.State != JOB_CANCELED {
+		t.Fatalf("cancel = %+v, want state %s", canceled, JOB_CANCELED)
+	}
+
+	if rejected := runtime.call(&ServiceCall{Name: "slow", Job: true}); rejected.Error == nil || rejected.Error.Code != SERVICE_OVERLOADED {
+		t.Errorf("job while the canceled handler runs = %+v, want %s", rejected, SERVICE_OVERLOADED)
+	}
+
+	close(release)
+	for i := 0; i < 100; i++ {
+		runtime.jobs.lock.Lock()
+		running, abandoned := runtime.jobs.running, runtime.jobs.canceled
+		runtime.jobs.lock.Unlock()
+		if running == 0 && abandoned == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("canceled job still counted after its handler returned")
+}
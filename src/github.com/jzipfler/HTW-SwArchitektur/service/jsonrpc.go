@@ -0,0 +1,229 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Whether services also accept requests in JSON-RPC 2.0 format. The
+	// format is detected per request, so both formats work side by side.
+	// Disabled by default, enabled by the environment variable HTW_JSONRPC.
+	JSONRPC_ENABLED = os.Getenv("HTW_JSONRPC") != ""
+)
+
+// Error codes of JSON-RPC 2.0.
+const (
+	JSONRPC_PARSE_ERROR      = -32700
+	JSONRPC_INVALID_REQUEST  = -32600
+	JSONRPC_METHOD_NOT_FOUND = -32601
+	JSONRPC_INVALID_PARAMS   = -32602
+	JSONRPC_INTERNAL_ERROR   = -32603
+	// the service reported a ServiceError
+	JSONRPC_SERVER_ERROR = -32000
+)
+
+// A JSON-RPC 2.0 request. The method is the service name, params are
+// either positional (array) or named by ArgumentInfo.Name (object).
+type jsonrpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// A JSON-RPC 2.0 error object.
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// A JSON-RPC 2.0 response, either with result or with error.
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  *string         `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Converts a JSON argument value to the string representation which is
+// passed to services (numbers without exponent, strings unquoted). Values
+// should be decoded with json.Decoder.UseNumber(), so that large integers
+// are passed exactly as json.Number instead of being rounded to float64.
+func FormatArgument(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		if !strings.ContainsAny(string(v), "eE") {
+			return string(v)
+		}
+		number, _, err := big.ParseFloat(string(v), 10, 1024, big.ToNearestEven)
+		if err != nil {
+			return string(v)
+		}
+		return number.Text('f', -1)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		bytes, _ := json.Marshal(v)
+		return string(bytes)
+	}
+}
+
+// Maps arguments named by ArgumentInfo.Name to the positional arguments
+// of the contract. All arguments of the contract are required.
+func NamedArguments(info *ServiceInfo, named map[string]interface{}) ([]string, error) {
+	args := []string{}
+	for _, argument := range contractArguments(info) {
+		value, ok := named[argument.Name]
+		if !ok {
+			return nil, fmt.Errorf("missing argument %q (%s)", argument.Name, argument.Type)
+		}
+		args = append(args, FormatArgument(value))
+	}
+	return args, nil
+}
+
+// Returns true if the request is in JSON-RPC 2.0 format (a batch or an
+// object with "jsonrpc" member).
+func isJSONRPC(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		return true
+	}
+	probe := struct {
+		JSONRPC *string `json:"jsonrpc"`
+	}{}
+	return json.Unmarshal(data, &probe) == nil && probe.JSONRPC != nil
+}
+
+// Decodes JSON data like json.Unmarshal(), but numbers in interface{}
+// values are decoded as json.Number, so that they keep their precision.
+func unmarshalNumbers(data []byte, value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
+
+// Returns the encoded response to a request which isn't valid JSON.
+func jsonrpcParseError() []byte {
+	result, _ := json.Marshal(jsonrpcResponse{JSONRPC: "2.0", Error: &jsonrpcError{JSONRPC_PARSE_ERROR, "parse error"}, ID: json.RawMessage("null")})
+	return result
+}
+
+// Returns the positional arguments for the params of a JSON-RPC request.
+func jsonrpcArguments(info *ServiceInfo, params json.RawMessage) ([]string, *jsonrpcError) {
+	expected := len(contractArguments(info))
+	params = bytes.TrimSpace(params)
+
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		if expected > 0 {
+			return nil, &jsonrpcError{JSONRPC_INVALID_PARAMS, fmt.Sprintf("expected %d params", expected)}
+		}
+		return []string{}, nil
+	}
+
+	if params[0] == '[' {
+		positional := []interface{}{}
+		if unmarshalNumbers(params, &positional) != nil {
+			return nil, &jsonrpcError{JSONRPC_INVALID_PARAMS, "invalid params"}
+		}
+		if len(positional) != expected {
+			return nil, &jsonrpcError{JSONRPC_INVALID_PARAMS, fmt.Sprintf("expected %d params, got %d", expected, len(positional))}
+		}
+		args := []string{}
+		for _, value := range positional {
+			args = append(args, FormatArgument(value))
+		}
+		return args, nil
+	}
+
+	named := make(map[string]interface{})
+	if unmarshalNumbers(params, &named) != nil {
+		return nil, &jsonrpcError{JSONRPC_INVALID_PARAMS, "params must be an array or an object"}
+	}
+	args, err := NamedArguments(info, named)
+	if err != nil {
+		return nil, &jsonrpcError{JSONRPC_INVALID_PARAMS, err.Error()}
+	}
+	return args, nil
+}
+
+// Handles a single JSON-RPC request. Returns nil for notifications
+// (requests without id), which aren't answered.
+func handleJSONRPCRequest(data json.RawMessage, caller string, runtime *serviceRuntime) *jsonrpcResponse {
+	request := jsonrpcRequest{}
+	err := json.Unmarshal(data, &request)
+	if err != nil || request.JSONRPC != "2.0" || request.Method == "" {
+		return &jsonrpcResponse{JSONRPC: "2.0", Error: &jsonrpcError{JSONRPC_INVALID_REQUEST, "invalid request"}, ID: json.RawMessage("null")}
+	}
+	response := &jsonrpcResponse{JSONRPC: "2.0", ID: request.ID}
+
+	args, rpcError := jsonrpcArguments(runtime.info, request.Params)
+	if CanonicalServiceName(request.Method) != CanonicalServiceName(runtime.info.Name) {
+		rpcError = &jsonrpcError{JSONRPC_METHOD_NOT_FOUND, "method not found: " + request.Method}
+	}
+	if rpcError != nil {
+		response.Error = rpcError
+	} else {
+		result := runtime.call(&ServiceCall{Name: request.Method, Arguments: args, Caller: caller})
+		if result.Error != nil {
+			code := JSONRPC_SERVER_ERROR
+			if result.Error.Code == SERVICE_INTERNAL {
+				code = JSONRPC_INTERNAL_ERROR
+			}
+			response.Error = &jsonrpcError{code, result.Error.Code + ": " + result.Error.Message}
+		} else {
+			response.Result = &result.Result
+		}
+	}
+
+	if len(request.ID) == 0 {
+		return nil
+	}
+	return response
+}
+
+// Handles a JSON-RPC request or batch of requests and returns the encoded
+// response, or nil if nothing has to be answered (only notifications).
+func handleJSONRPC(data []byte, caller string, runtime *serviceRuntime) []byte {
+	data = bytes.TrimSpace(data)
+
+	if data[0] != '[' {
+		response := handleJSONRPCRequest(data, caller, runtime)
+		if response == nil {
+			return nil
+		}
+		result, _ := json.Marshal(response)
+		return result
+	}
+
+	batch := []json.RawMessage{}
+	if json.Unmarshal(data, &batch) != nil {
+		return jsonrpcParseError()
+	}
+	if len(batch) == 0 {
+		result, _ := json.Marshal(jsonrpcResponse{JSONRPC: "2.0", Error: &jsonrpcError{JSONRPC_INVALID_REQUEST, "empty batch"}, ID: json.RawMessage("null")})
+		return result
+	}
+
+	responses := []*jsonrpcResponse{}
+	for _, request := range batch {
+		if response := handleJSONRPCRequest(request, caller, runtime); response != nil {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	result, _ := json.Marshal(responses)
+	return result
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFormatArgument(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		argument string
+	}{
+		{"text", "text"},
+		{nil, ""},
+		{true, "true"},
+		{float64(7), "7"},
+		{1.5, "1.5"},
+		{json.Number("9007199254740993"), "9007199254740993"},
+		{json.Number("-12.50"), "-12.50"},
+		{json.Number("1e3"), "1000"},
+		{json.Number("1.5E-3"), "0.0015"},
+		{[]interface{}{json.Number("1"), "a"}, `[1,"a"]`},
+		{map[string]interface{}{"a": json.Number("2")}, `{"a":2}`},
+	}
+
+	for _, test := range tests {
+		if argument := FormatArgument(test.value); argument != test.argument {
+			t.Errorf("FormatArgument(%#v) = %q, want %q", test.value, argument, test.argument)
+		}
+	}
+}
+
+func TestJSONRPCArguments(t *testing.T) {
+	info := &ServiceInfo{Name: "add", Arguments: []ArgumentInfo{{"a", "int", ""}, {"b", "string", ""}}}
+	void := &ServiceInfo{Name: "random", Arguments: []ArgumentInfo{{"", "void", ""}}}
+	tests := []struct {
+		info   *ServiceInfo
+		params string
+		args   []string
+		code   int
+	}{
+		{info, `[1, "x"]`, []string{"1", "x"}, 0},
+		{info, `{"b": "x", "a": 2}`, []string{"2", "x"}, 0},
+		{info, `[123456789012345678901234567890, "x"]`, []string{"123456789012345678901234567890", "x"}, 0},
+		{info, `{"a": 9007199254740993, "b": 1.0}`, []string{"9007199254740993", "1.0"}, 0},
+		{info, `[1]`, nil, JSONRPC_INVALID_PARAMS},
+		{info, `{"a": 1}`, nil, JSONRPC_INVALID_PARAMS},
+		{info, ``, nil, JSONRPC_INVALID_PARAMS},
+		{info, `"a"`, nil, JSONRPC_INVALID_PARAMS},
+		{info, `[1, `, nil, JSONRPC_INVALID_PARAMS},
+		{void, ``, []string{}, 0},
+		{void, `null`, []string{}, 0},
+		{void, `[]`, []string{}, 0},
+		{void, `{}`, []string{}, 0},
+	}
+
+	for _, test := range tests {
+		args, err := jsonrpcArguments(test.info, json.RawMessage(test.params))
+		switch {
+		case test.code != 0 && (err == nil || err.Code != test.code):
+			t.Errorf("jsonrpcArguments(%s) = %v, %v, want error %d", test.params, args, err, test.code)
+		case test.code == 0 && (err != nil || !reflect.DeepEqual(args, test.args)):
+			t.Errorf("jsonrpcArguments(%s) = %v, %v, want %v", test.params, args, err, test.args)
+		}
+	}
+}
+
+func TestIsJSONRPC(t *testing.T) {
+	tests := []struct {
+		data    string
+		jsonrpc bool
+	}{
+		{`{"jsonrpc": "2.0", "method": "add"}`, true},
+		{` [{"jsonrpc": "2.0"}]`, true},
+		{`{"Name": "add", "Arguments": ["1"]}`, false},
+		{`{"Name": "add", "jsonrpc": null}`, false},
+	}
+
+	for _, test := range tests {
+		if jsonrpc := isJSONRPC([]byte(test.data)); jsonrpc != test.jsonrpc {
+			t.Errorf("isJSONRPC(%s) = %v, want %v", test.data, jsonrpc, test.jsonrpc)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Maximum number of calls a service handles concurrently (0 = no
+	// limit). Further calls wait in a queue.
+	MAX_CONCURRENT_CALLS = 64
+	// Maximum number of calls waiting for a free slot. Calls beyond are
+	// rejected immediately with SERVICE_OVERLOADED.
+	MAX_QUEUED_CALLS = 256
+	// Maximum time a call waits in the queue before it's rejected with
+	// SERVICE_OVERLOADED (0 = no limit).
+	QUEUE_TIMEOUT = 5 * time.Second
+	// Maximum number of connections a service serves at the same time (0 =
+	// no limit). Further connections aren't accepted before one is closed,
+	// so they wait in the backlog of the listener.
+	MAX_CONNECTIONS = 1024
+)
+
+// Codes of ServiceError.
+const (
+	// The service rejected the call because too many calls are active
+	// and queued. The call wasn't executed.
+	SERVICE_OVERLOADED = "overloaded"
+)
+
+// Error which a service reports instead of a result (see
+// ServiceResult.Error). It's returned by CallService().
+type ServiceError struct {
+	Code    string
+	Message string
+}
+
+// Returns the code and message of the error.
+func (err *ServiceError) Error() string {
+	return "error: " + err.Code + ": " + err.Message
+}
+
+// Limits the calls of a service to MAX_CONCURRENT_CALLS active and
+// MAX_QUEUED_CALLS queued calls.
+type callLimiter struct {
+	slots   chan struct{}
+	queued  int64
+	timeout time.Duration
+	// maximum number of queued calls
+	queue int64
+}
+
+// Accepts connections on the listener and serves each with the handler,
+// at most MAX_CONNECTIONS at the same time. Returns the error of the
+// listener.
+func acceptConnections(listener *net.TCPListener, handle func(connection *net.TCPConn)) error {
+	var slots chan struct{}
+	if MAX_CONNECTIONS > 0 {
+		slots = make(chan struct{}, MAX_CONNECTIONS)
+	}
+	for {
+		if slots != nil {
+			slots <- struct{}{}
+		}
+		connection, err := listener.AcceptTCP()
+		if err != nil {
+			if slots != nil {
+				<-slots
+			}
+			if netError, ok := err.(net.Error); ok && netError.Temporary() {
+				continue
+			}
+			return err
+		}
+		go func() {
+			handle(connection)
+			if slots != nil {
+				<-slots
+			}
+		}()
+	}
+}
+
+// Returns a limiter with the current limits, or nil if the number of
+// concurrent calls isn't limited.
+func newCallLimiter() *callLimiter {
+	if MAX_CONCURRENT_CALLS <= 0 {
+		return nil
+	}
+	return &callLimiter{
+		slots:   make(chan struct{}, MAX_CONCURRENT_CALLS),
+		timeout: QUEUE_TIMEOUT,
+		queue:   int64(MAX_QUEUED_CALLS),
+	}
+}
+
+// Waits for a free slot. Returns a SERVICE_OVERLOADED error if the queue
+// is full or the call waited longer than the timeout. Calls which get a
+// slot must release() it.
+func (limiter *callLimiter) acquire() *ServiceError {
+	if limiter == nil {
+		return nil
+	}
+	select {
+	case limiter.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	queued := atomic.AddInt64(&limiter.queued, 1)
+	defer atomic.AddInt64(&limiter.queued, -1)
+	if queued > limiter.queue {
+		return &ServiceError{SERVICE_OVERLOADED, strconv.Itoa(cap(limiter.slots)) + " active and " + strconv.FormatInt(limiter.queue, 10) + " queued calls"}
+	}
+
+	var timeout <-chan time.Time
+	if limiter.timeout > 0 {
+		timer := time.NewTimer(limiter.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case limiter.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return &ServiceError{SERVICE_OVERLOADED, "queued longer than " + limiter.timeout.String()}
+	}
+}
+
+// Frees the slot of a call.
+func (limiter *callLimiter) release() {
+	if limiter != nil {
+		<-limiter.slots
+	}
+}
+
+// Returns the number of queued calls.
+func (limiter *callLimiter) queuedCalls() int64 {
+	if limiter == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&limiter.queued)
+}
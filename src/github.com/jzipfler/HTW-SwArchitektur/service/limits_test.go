@@ -0,0 +1,52 @@
+package service
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcceptConnectionsLimit(t *testing.T) {
+	defer func(connections int) { MAX_CONNECTIONS = connections }(MAX_CONNECTIONS)
+	MAX_CONNECTIONS = 2
+
+	listener, err := net.ListenTCP(TCP_PROTOCOL, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var served int64
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- acceptConnections(listener, func(connection *net.TCPConn) {
+			atomic.AddInt64(&served, 1)
+			<-release
+			connection.Close()
+		})
+	}()
+
+	for i := 0; i < 3; i++ {
+		connection, err := net.Dial(TCP_PROTOCOL, listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer connection.Close()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if count := atomic.LoadInt64(&served); count != 2 {
+		t.Errorf("%d connections served at the same time, want 2", count)
+	}
+
+	release <- struct{}{}
+	time.Sleep(50 * time.Millisecond)
+	if count := atomic.LoadInt64(&served); count != 3 {
+		t.Errorf("%d connections served after one was closed, want 3", count)
+	}
+
+	close(release)
+	listener.Close()
+	if err := <-done; err == nil {
+		t.Error("acceptConnections() returned no error for a closed listener")
+	}
+}
@@ -0,0 +1,591 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Multicast address used by mDNS.
+	MDNS_ADDR = &net.UDPAddr{IP: net.ParseIP("224.0.0.251"), Port: 5353}
+	// DNS-SD service type under which services are published.
+	MDNS_SERVICE_TYPE = "_htwsvc._tcp.local."
+	// Time to wait for mDNS responses when resolving services.
+	MDNS_TIMEOUT = time.Second
+	// Time to live of published mDNS records in seconds.
+	MDNS_TTL = uint32(120)
+	// Whether the registry publishes its services via mDNS / DNS-SD.
+	REGISTRY_MDNS = false
+)
+
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+	dnsClassIN = 1
+	// Service type enumeration of DNS-SD (RFC 6763, section 9).
+	dnsServiceEnumeration = "_services._dns-sd._udp.local."
+)
+
+// A DNS question (name and record type).
+type dnsQuestion struct {
+	Name string
+	Type uint16
+}
+
+// A DNS resource record. Depending on the type, the data is a name
+// (PTR), a target with port (SRV), strings (TXT) or an IP (A).
+type dnsRecord struct {
+	Name   string
+	Type   uint16
+	TTL    uint32
+	Target string
+	Port   uint16
+	Text   []string
+	IP     net.IP
+}
+
+// A DNS message, reduced to the parts needed for DNS-SD.
+type dnsMessage struct {
+	ID        uint16
+	Response  bool
+	Questions []dnsQuestion
+	Answers   []dnsRecord
+}
+
+// Appends a domain name in DNS wire format (without compression).
+func appendDNSName(buffer []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		buffer = append(buffer, byte(len(label)))
+		buffer = append(buffer, label...)
+	}
+	return append(buffer, 0)
+}
+
+// Encodes the message in DNS wire format. All answers are sent in the
+// answer section, which is accepted by all mDNS implementations.
+func (m *dnsMessage) pack() []byte {
+	buffer := make([]byte, 12)
+	binary.BigEndian.PutUint16(buffer[0:], m.ID)
+	if m.Response {
+		binary.BigEndian.PutUint16(buffer[2:], 0x8400) // response, authoritative
+	}
+	binary.BigEndian.PutUint16(buffer[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(buffer[6:], uint16(len(m.Answers)))
+
+	for _, question := range m.Questions {
+		buffer = appendDNSName(buffer, question.Name)
+		buffer = append(buffer, byte(question.Type>>8), byte(question.Type), 0, dnsClassIN)
+	}
+
+	for _, record := range m.Answers {
+		data := []byte{}
+		switch record.Type {
+		case dnsTypePTR:
+			data = appendDNSName(data, record.Target)
+		case dnsTypeSRV:
+			data = append(data, 0, 0, 0, 0, byte(record.Port>>8), byte(record.Port))
+			data = appendDNSName(data, record.Target)
+		case dnsTypeTXT:
+			for _, text := range record.Text {
+				if len(text) > 255 {
+					text = text[:255]
+				}
+				data = append(data, byte(len(text)))
+				data = append(data, text...)
+			}
+		case dnsTypeA:
+			data = append(data, record.IP.To4()...)
+		}
+
+		buffer = appendDNSName(buffer, record.Name)
+		header := make([]byte, 10)
+		binary.BigEndian.PutUint16(header[0:], record.Type)
+		binary.BigEndian.PutUint16(header[2:], dnsClassIN)
+		binary.BigEndian.PutUint32(header[4:], record.TTL)
+		binary.BigEndian.PutUint16(header[8:], uint16(len(data)))
+		buffer = append(buffer, header...)
+		buffer = append(buffer, data...)
+	}
+
+	return buffer
+}
+
+// Reads a (possibly compressed) domain name at the given offset and
+// returns it together with the offset behind the name.
+func readDNSName(buffer []byte, offset int) (string, int, error) {
+	labels := []string{}
+	end := -1
+
+	for jumps := 0; ; {
+		if offset >= len(buffer) {
+			return "", 0, errors.New("error: truncated DNS name")
+		}
+		length := int(buffer[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(buffer) || jumps > 10 {
+				return "", 0, errors.New("error: invalid DNS name compression")
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(buffer[offset:]) & 0x3FFF)
+			jumps++
+		default:
+			if offset+1+length > len(buffer) {
+				return "", 0, errors.New("error: truncated DNS name")
+			}
+			labels = append(labels, string(buffer[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// Decodes a message in DNS wire format. Authority and additional
+// records are treated like answers.
+func unpackDNSMessage(buffer []byte) (*dnsMessage, error) {
+	if len(buffer) < 12 {
+		return nil, errors.New("error: truncated DNS message")
+	}
+	message := &dnsMessage{
+		ID:       binary.BigEndian.Uint16(buffer[0:]),
+		Response: buffer[2]&0x80 != 0,
+	}
+	questions := int(binary.BigEndian.Uint16(buffer[4:]))
+	records := 0
+	for i := 6; i < 12; i += 2 {
+		records += int(binary.BigEndian.Uint16(buffer[i:]))
+	}
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		name, next, err := readDNSName(buffer, offset)
+		if err != nil || next+4 > len(buffer) {
+			return nil, errors.New("error: truncated DNS question")
+		}
+		message.Questions = append(message.Questions, dnsQuestion{name, binary.BigEndian.Uint16(buffer[next:])})
+		offset = next + 4
+	}
+
+	for i := 0; i < records; i++ {
+		name, next, err := readDNSName(buffer, offset)
+		if err != nil || next+10 > len(buffer) {
+			return nil, errors.New("error: truncated DNS record")
+		}
+		record := dnsRecord{
+			Name: name,
+			Type: binary.BigEndian.Uint16(buffer[next:]),
+			TTL:  binary.BigEndian.Uint32(buffer[next+4:]),
+		}
+		start := next + 10
+		end := start + int(binary.BigEndian.Uint16(buffer[next+8:]))
+		if end > len(buffer) {
+			return nil, errors.New("error: truncated DNS record")
+		}
+
+		switch record.Type {
+		case dnsTypePTR:
+			record.Target, _, err = readDNSName(buffer, start)
+		case dnsTypeSRV:
+			if end-start < 7 {
+				return nil, errors.New("error: truncated SRV record")
+			}
+			record.Port = binary.BigEndian.Uint16(buffer[start+4:])
+			record.Target, _, err = readDNSName(buffer, start+6)
+		case dnsTypeTXT:
+			for position := start; position < end; {
+				length := int(buffer[position])
+				if position+1+length > end {
+					break
+				}
+				record.Text = append(record.Text, string(buffer[position+1:position+1+length]))
+				position += 1 + length
+			}
+		case dnsTypeA:
+			if end-start == 4 {
+				record.IP = net.IP(append([]byte{}, buffer[start:end]...))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		message.Answers = append(message.Answers, record)
+		offset = end
+	}
+
+	return message, nil
+}
+
+// Returns the DNS-SD instance name of a service instance. The port is
+// part of the name, so several instances of one version stay distinct.
+// Labels longer than 63 bytes are shortened and get a hash of the full
+// label, so that long names don't collide.
+func mdnsInstanceName(instance ServiceInfoAddress) string {
+	_, port, _ := net.SplitHostPort(instance.Address)
+	label := strings.Replace(instance.Info.Name+"@"+instance.Info.Version+":"+port, ".", "_", -1)
+	if len(label) > 63 {
+		hash := fnv.New32a()
+		hash.Write([]byte(label))
+		label = fmt.Sprintf("%s~%08x", label[:54], hash.Sum32())
+	}
+	return label + "." + MDNS_SERVICE_TYPE
+}
+
+// Returns the host name which is used as SRV target for the given IP.
+func mdnsHostName(ip net.IP) string {
+	return "htw-" + strings.Replace(ip.String(), ".", "-", -1) + ".local."
+}
+
+// Returns the TXT record strings ("key=value") for a service contract.
+func contractTXT(info *ServiceInfo) []string {
+	text := []string{
+		"name=" + info.Name,
+		"version=" + info.Version,
+		"result=" + info.ResultType,
+		"desc=" + info.Description,
+	}
+	if info.Idempotent {
+		text = append(text, "idempotent=1")
+	}
+	if info.Streaming {
+		text = append(text, "streaming=1")
+	}
+	if info.Interactive {
+		text = append(text, "interactive=1")
+	}
+	for i, argument := range info.Arguments {
+		text = append(text, fmt.Sprintf("arg%d=%s:%s:%s", i+1, argument.Name, argument.Type, argument.Description))
+	}
+	return text
+}
+
+// Restores a service contract from TXT record strings, see contractTXT().
+func contractFromTXT(text []string) ServiceInfo {
+	info := ServiceInfo{}
+	arguments := make(map[int]ArgumentInfo)
+
+	for _, entry := range text {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch key, value := parts[0], parts[1]; {
+		case key == "name":
+			info.Name = value
+		case key == "version":
+			info.Version = value
+		case key == "result":
+			info.ResultType = value
+		case key == "desc":
+			info.Description = value
+		case key == "idempotent":
+			info.Idempotent = value == "1"
+		case key == "streaming":
+			info.Streaming = value == "1"
+		case key == "interactive":
+			info.Interactive = value == "1"
+		case strings.HasPrefix(key, "arg"):
+			index, err := strconv.Atoi(key[3:])
+			fields := strings.SplitN(value, ":", 3)
+			if err == nil && len(fields) == 3 {
+				arguments[index] = ArgumentInfo{fields[0], fields[1], fields[2]}
+			}
+		}
+	}
+
+	for i := 1; i <= len(arguments); i++ {
+		if argument, ok := arguments[i]; ok {
+			info.Arguments = append(info.Arguments, argument)
+		}
+	}
+	return info
+}
+
+// Returns all records of the given instances which answer the question.
+// Loopback addresses of instances are replaced with the given IP.
+func mdnsAnswers(question dnsQuestion, instances []ServiceInfoAddress, ip net.IP) []dnsRecord {
+	answers := []dnsRecord{}
+	matches := func(name string, recordType uint16) bool {
+		return strings.EqualFold(question.Name, name) && (question.Type == recordType || question.Type == dnsTypeANY)
+	}
+
+	if matches(dnsServiceEnumeration, dnsTypePTR) && len(instances) > 0 {
+		answers = append(answers, dnsRecord{Name: dnsServiceEnumeration, Type: dnsTypePTR, TTL: MDNS_TTL, Target: MDNS_SERVICE_TYPE})
+	}
+
+	for _, instance := range instances {
+		host, portString, err := net.SplitHostPort(instance.Address)
+		if err != nil {
+			continue
+		}
+		port, _ := strconv.Atoi(portString)
+		address := net.ParseIP(host)
+		if address == nil || address.IsLoopback() || address.IsUnspecified() {
+			address = ip
+		}
+		name := mdnsInstanceName(instance)
+		target := mdnsHostName(address)
+
+		srv := dnsRecord{Name: name, Type: dnsTypeSRV, TTL: MDNS_TTL, Target: target, Port: uint16(port)}
+		txt := dnsRecord{Name: name, Type: dnsTypeTXT, TTL: MDNS_TTL, Text: contractTXT(&instance.Info)}
+		a := dnsRecord{Name: target, Type: dnsTypeA, TTL: MDNS_TTL, IP: address}
+
+		switch {
+		case matches(MDNS_SERVICE_TYPE, dnsTypePTR):
+			ptr := dnsRecord{Name: MDNS_SERVICE_TYPE, Type: dnsTypePTR, TTL: MDNS_TTL, Target: name}
+			answers = append(answers, ptr, srv, txt, a)
+		case matches(name, dnsTypeSRV) || matches(name, dnsTypeTXT):
+			answers = append(answers, srv, txt, a)
+		case matches(target, dnsTypeA):
+			answers = append(answers, a)
+		}
+	}
+
+	return answers
+}
+
+// Returns the first IPv4 address of the interface, or nil.
+func interfaceIPv4(intf net.Interface) net.IP {
+	addresses, err := intf.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, address := range addresses {
+		if network, ok := address.(*net.IPNet); ok && network.IP.To4() != nil {
+			return network.IP.To4()
+		}
+	}
+	return nil
+}
+
+// mDNS responder on the connection of an interface with the given IP,
+// which answers queries for the instances returned by the given function.
+func mdnsResponderOnInterface(connection *net.UDPConn, ip net.IP, instances func() []ServiceInfoAddress) {
+	buffer := make([]byte, PACKET_SIZE)
+	defer connection.Close()
+
+	for {
+		length, sender, err := connection.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		query, err := unpackDNSMessage(buffer[:length])
+		if err != nil || query.Response {
+			continue
+		}
+
+		response := dnsMessage{ID: query.ID, Response: true}
+		for _, question := range query.Questions {
+			response.Answers = append(response.Answers, mdnsAnswers(question, instances(), ip)...)
+		}
+		if len(response.Answers) == 0 {
+			continue
+		}
+
+		// queries from port 5353 are answered via multicast, all others
+		// ("legacy unicast" queries, RFC 6762 section 6.7) directly
+		destination := sender
+		if sender.Port == MDNS_ADDR.Port {
+			destination = MDNS_ADDR
+		} else {
+			response.Questions = query.Questions
+		}
+		connection.WriteToUDP(response.pack(), destination)
+	}
+}
+
+// Starts an mDNS responder on all interfaces with an IPv4 address, which
+// publishes the instances returned by the given function as DNS-SD
+// services. Returns an error if no interface could be used.
+func mdnsResponder(instances func() []ServiceInfoAddress) error {
+	intf, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	responders := 0
+	for _, i := range intf {
+		ip := interfaceIPv4(i)
+		if ip == nil {
+			continue
+		}
+		connection, err := net.ListenMulticastUDP(UDP_PROTOCOL, &i, MDNS_ADDR)
+		if err != nil {
+			fmt.Println("mDNS unavailable on interface", i.Name+":", err)
+			continue
+		}
+		go mdnsResponderOnInterface(connection, ip, instances)
+		responders++
+	}
+
+	if responders == 0 {
+		return errors.New("error: mDNS is unavailable on all interfaces")
+	}
+	return nil
+}
+
+// Publishes all available services registered at the registry via mDNS.
+// Returns an error if mDNS is unavailable on all interfaces.
+func registryMDNSResponder() error {
+	return mdnsResponder(func() []ServiceInfoAddress {
+		servicesLock.Lock()
+		defer servicesLock.Unlock()
+
+		instances := []ServiceInfoAddress{}
+		for _, registered := range services {
+			for _, instance := range registered {
+				if instanceAvailable(instance) {
+					instances = append(instances, instance)
+				}
+			}
+		}
+		return instances
+	})
+}
+
+// Publishes a single service, which listens on the given port, via mDNS.
+// Returns an error if mDNS is unavailable on all interfaces.
+func publishServiceMDNS(serviceinfo *ServiceInfo, port int) error {
+	instance := ServiceInfoAddress{Address: net.JoinHostPort("0.0.0.0", strconv.Itoa(port)), Info: *serviceinfo}
+	return mdnsResponder(func() []ServiceInfoAddress {
+		return []ServiceInfoAddress{instance}
+	})
+}
+
+// Discovers all services published via mDNS / DNS-SD. The mapping of the
+// result is from canonical service name to instances.
+func ResolveServicesMDNS() (map[string][]ServiceInfoAddress, error) {
+	return resolveServicesMDNS(nil)
+}
+
+// Discovers services published via mDNS / DNS-SD until MDNS_TIMEOUT
+// elapsed or, if done is given, until it returns true for the services
+// discovered so far.
+func resolveServicesMDNS(done func(known map[string][]ServiceInfoAddress) bool) (map[string][]ServiceInfoAddress, error) {
+	buffer := make([]byte, PACKET_SIZE)
+	records := []dnsRecord{}
+
+	connection, err := net.ListenUDP(UDP_PROTOCOL, UDP_ANY_ADDR)
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+
+	query := dnsMessage{ID: uint16(time.Now().UnixNano()), Questions: []dnsQuestion{{MDNS_SERVICE_TYPE, dnsTypePTR}}}
+	_, err = connection.WriteToUDP(query.pack(), MDNS_ADDR)
+	if err != nil {
+		return nil, err
+	}
+
+	connection.SetReadDeadline(time.Now().Add(MDNS_TIMEOUT))
+	for {
+		length, _, err := connection.ReadFromUDP(buffer)
+		if err != nil {
+			break
+		}
+		response, err := unpackDNSMessage(buffer[:length])
+		if err == nil && response.Response {
+			records = append(records, response.Answers...)
+			if done != nil && done(servicesFromRecords(records)) {
+				break
+			}
+		}
+	}
+
+	return servicesFromRecords(records), nil
+}
+
+// Assembles service instances from the PTR, SRV, TXT and A records of
+// mDNS responses. The mapping is from canonical service name to instances.
+func servicesFromRecords(records []dnsRecord) map[string][]ServiceInfoAddress {
+	result := make(map[string][]ServiceInfoAddress)
+	srv := make(map[string]dnsRecord)
+	txt := make(map[string][]string)
+	hosts := make(map[string]net.IP)
+	names := []string{}
+
+	for _, record := range records {
+		key := strings.ToLower(record.Name)
+		switch record.Type {
+		case dnsTypePTR:
+			if strings.EqualFold(record.Name, MDNS_SERVICE_TYPE) {
+				names = append(names, strings.ToLower(record.Target))
+			}
+		case dnsTypeSRV:
+			srv[key] = record
+		case dnsTypeTXT:
+			txt[key] = record.Text
+		case dnsTypeA:
+			hosts[key] = record.IP
+		}
+	}
+
+	sort.Strings(names)
+	seen := make(map[string]bool)
+	for _, name := range names {
+		record, ok := srv[name]
+		ip := hosts[strings.ToLower(record.Target)]
+		if !ok || ip == nil || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		info := contractFromTXT(txt[name])
+		if info.Name == "" {
+			continue
+		}
+		info.Name = CanonicalServiceName(info.Name)
+		instance := ServiceInfoAddress{Address: net.JoinHostPort(ip.String(), strconv.Itoa(int(record.Port))), Info: info}
+		result[info.Name] = append(result[info.Name], instance)
+	}
+
+	return result
+}
+
+// Answers a lookup request from the services discovered via mDNS. Lookups
+// of an address or info return as soon as an instance which satisfies the
+// version constraint answered (which isn't necessarily the highest
+// version), all other lookups wait MDNS_TIMEOUT for all answers.
+func lookupMDNS(request *LookupInfoRequest) ([]byte, error) {
+	if request.Operation != OPERATION_LIST {
+		request.ServiceName = CanonicalServiceName(request.ServiceName)
+	}
+
+	var done func(known map[string][]ServiceInfoAddress) bool
+	if request.Operation == OPERATION_ADDRESS || request.Operation == OPERATION_INFO {
+		done = func(known map[string][]ServiceInfoAddress) bool {
+			_, found, _ := selectInstance(known[request.ServiceName], request.Version)
+			return found
+		}
+	}
+	known, err := resolveServicesMDNS(done)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, ok := lookupResponse(request, known)
+	if !ok {
+		return nil, errors.New("error: operation \"" + request.Operation + "\" is not supported via mDNS")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDNSMessagePackUnpack(t *testing.T) {
+	message := dnsMessage{
+		ID:        0x1234,
+		Response:  true,
+		Questions: []dnsQuestion{{MDNS_SERVICE_TYPE, dnsTypePTR}},
+		Answers: []dnsRecord{
+			{Name: MDNS_SERVICE_TYPE, Type: dnsTypePTR, TTL: 120, Target: "isprime@1_0_0:4000." + MDNS_SERVICE_TYPE},
+			{Name: "isprime@1_0_0:4000." + MDNS_SERVICE_TYPE, Type: dnsTypeSRV, TTL: 120, Target: "htw-10-0-0-1.local.", Port: 4000},
+			{Name: "isprime@1_0_0:4000." + MDNS_SERVICE_TYPE, Type: dnsTypeTXT, TTL: 120, Text: []string{"name=isprime", "version=1.0.0"}},
+			{Name: "htw-10-0-0-1.local.", Type: dnsTypeA, TTL: 120, IP: net.IP{10, 0, 0, 1}},
+		},
+	}
+
+	unpacked, err := unpackDNSMessage(message.pack())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*unpacked, message) {
+		t.Errorf("unpackDNSMessage(pack()) = %+v, want %+v", *unpacked, message)
+	}
+}
+
+func TestReadDNSName(t *testing.T) {
+	// "local." at offset 0, "a.local." using a pointer to it at offset 7
+	buffer := []byte{5, 'l', 'o', 'c', 'a', 'l', 0, 1, 'a', 0xC0, 0}
+	tests := []struct {
+		offset int
+		name   string
+		next   int
+		valid  bool
+	}{
+		{0, "local.", 7, true},
+		{7, "a.local.", 11, true},
+		{9, "local.", 11, true},
+		{1, "", 0, false},
+	}
+
+	for _, test := range tests {
+		name, next, err := readDNSName(buffer, test.offset)
+		if (err == nil) != test.valid {
+			t.Errorf("readDNSName(%d): error %v, valid %v", test.offset, err, test.valid)
+		} else if test.valid && (name != test.name || next != test.next) {
+			t.Errorf("readDNSName(%d) = %q, %d, want %q, %d", test.offset, name, next, test.name, test.next)
+		}
+	}
+
+	loop := []byte{0xC0, 0}
+	if _, _, err := readDNSName(loop, 0); err == nil {
+		t.Error("readDNSName() of a compression loop: no error")
+	}
+}
+
+func TestUnpackDNSMessageTruncated(t *testing.T) {
+	message := dnsMessage{
+		Response: true,
+		Answers:  []dnsRecord{{Name: "a.local.", Type: dnsTypeSRV, TTL: 120, Target: "b.local.", Port: 80}},
+	}
+	packed := message.pack()
+
+	for length := 0; length < len(packed); length++ {
+		if _, err := unpackDNSMessage(packed[:length]); err == nil {
+			t.Errorf("unpackDNSMessage() of %d of %d bytes: no error", length, len(packed))
+		}
+	}
+}
+
+func TestContractTXT(t *testing.T) {
+	info := ServiceInfo{
+		Name:        "team-a/isprime",
+		Version:     "1.2.0",
+		ResultType:  "string",
+		Description: "Checks whether x is prime.",
+		Arguments:   []ArgumentInfo{{"x", "int", "number: to test"}, {"y", "string", ""}},
+		Idempotent:  true,
+		Streaming:   true,
+	}
+
+	restored := contractFromTXT(contractTXT(&info))
+	if !reflect.DeepEqual(restored, info) {
+		t.Errorf("contractFromTXT(contractTXT()) = %+v, want %+v", restored, info)
+	}
+}
+
+func TestMDNSInstanceName(t *testing.T) {
+	long := strings.Repeat("x", 70)
+	names := make(map[string]bool)
+	for _, name := range []string{long + "a", long + "b", "isprime"} {
+		instance := ServiceInfoAddress{Address: "10.0.0.1:4000", Info: ServiceInfo{Name: name, Version: "1.0.0"}}
+		label := strings.TrimSuffix(mdnsInstanceName(instance), "."+MDNS_SERVICE_TYPE)
+		if len(label) > 63 || strings.Contains(label, ".") {
+			t.Errorf("mdnsInstanceName(%q) = invalid label %q", name, label)
+		}
+		if names[label] {
+			t.Errorf("mdnsInstanceName(%q) = duplicate label %q", name, label)
+		}
+		names[label] = true
+	}
+}
+
+func TestMDNSAnswersResolve(t *testing.T) {
+	instances := []ServiceInfoAddress{
+		{Address: "0.0.0.0:4000", Info: ServiceInfo{Name: "isprime", Version: "1.0.0", ResultType: "string"}},
+		{Address: "10.0.0.2:4001", Info: ServiceInfo{Name: "random", Version: "2.0.0", ResultType: "int"}},
+	}
+	ip := net.IP{10, 0, 0, 1}
+
+	answers := mdnsAnswers(dnsQuestion{MDNS_SERVICE_TYPE, dnsTypePTR}, instances, ip)
+	response := dnsMessage{Response: true, Answers: answers}
+	unpacked, err := unpackDNSMessage(response.pack())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	known := servicesFromRecords(unpacked.Answers)
+	want := map[string][]ServiceInfoAddress{
+		"isprime": {{Address: "10.0.0.1:4000", Info: ServiceInfo{Name: "isprime", Version: "1.0.0", ResultType: "string"}}},
+		"random":  {{Address: "10.0.0.2:4001", Info: ServiceInfo{Name: "random", Version: "2.0.0", ResultType: "int"}}},
+	}
+	if !reflect.DeepEqual(known, want) {
+		t.Errorf("servicesFromRecords() = %+v, want %+v", known, want)
+	}
+
+	if answers := mdnsAnswers(dnsQuestion{"other._tcp.local.", dnsTypePTR}, instances, ip); len(answers) != 0 {
+		t.Errorf("mdnsAnswers() for another service type = %+v", answers)
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Namespace of all service names without an explicit namespace.
+	DEFAULT_NAMESPACE = "default"
+	// Separator between namespace and service name (e.g. "team-a/random").
+	NAMESPACE_SEPARATOR = "/"
+	// Maximum number of service instances per namespace which the registry
+	// accepts, unless NAMESPACE_QUOTAS contains a value for the namespace.
+	// A value <= 0 means unlimited.
+	DEFAULT_NAMESPACE_QUOTA = 0
+	// Maximum number of service instances for specific namespaces.
+	NAMESPACE_QUOTAS = make(map[string]int)
+)
+
+// Splits a service name like "team-a/random" into its namespace and the
+// name within the namespace. Names without namespace belong to the
+// DEFAULT_NAMESPACE.
+func SplitServiceName(name string) (namespace, local string) {
+	index := strings.Index(name, NAMESPACE_SEPARATOR)
+	if index < 0 {
+		return DEFAULT_NAMESPACE, name
+	}
+	return name[:index], name[index+len(NAMESPACE_SEPARATOR):]
+}
+
+// Returns the name under which a service is stored in the registry.
+// Services in the default namespace are stored without namespace, so
+// "random" and "default/random" are the same service.
+func CanonicalServiceName(name string) string {
+	namespace, local := SplitServiceName(name)
+	if namespace == DEFAULT_NAMESPACE {
+		return local
+	}
+	return namespace + NAMESPACE_SEPARATOR + local
+}
+
+// Checks whether the given service name is valid, i.e. neither the
+// namespace nor the name itself is empty or contains another separator.
+func ValidateServiceName(name string) error {
+	namespace, local := SplitServiceName(name)
+	if namespace == "" || local == "" || strings.Contains(local, NAMESPACE_SEPARATOR) {
+		return errors.New("error: invalid service name \"" + name + "\"")
+	}
+	return nil
+}
+
+// Returns true if the (canonical) service name belongs to the namespace.
+// An empty namespace matches all services.
+func inNamespace(name, namespace string) bool {
+	if namespace == "" {
+		return true
+	}
+	ns, _ := SplitServiceName(name)
+	return ns == namespace
+}
+
+// Checks whether another instance of the given service may be registered
+// without exceeding the quota of its namespace. The caller must hold
+// servicesLock.
+func checkNamespaceQuota(candidate ServiceInfoAddress) error {
+	namespace, _ := SplitServiceName(candidate.Info.Name)
+	quota, ok := NAMESPACE_QUOTAS[namespace]
+	if !ok {
+		quota = DEFAULT_NAMESPACE_QUOTA
+	}
+	if quota <= 0 {
+		return nil
+	}
+
+	count := 0
+	for name, instances := range services {
+		if !inNamespace(name, namespace) {
+			continue
+		}
+		for _, instance := range instances {
+			if name == candidate.Info.Name && replacesInstance(instance, candidate) {
+				// replaces an existing registration
+				return nil
+			}
+			count++
+		}
+	}
+	if count >= quota {
+		return errors.New("error: quota of namespace \"" + namespace + "\" exceeded (" + strconv.Itoa(quota) + " services)")
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import "testing"
+
+func TestServiceNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		local     string
+		canonical string
+		valid     bool
+	}{
+		{"random", DEFAULT_NAMESPACE, "random", "random", true},
+		{"default/random", DEFAULT_NAMESPACE, "random", "random", true},
+		{"team-a/random", "team-a", "random", "team-a/random", true},
+		{"team-a/", "team-a", "", "team-a/", false},
+		{"/random", "", "random", "/random", false},
+		{"team-a/sub/random", "team-a", "sub/random", "team-a/sub/random", false},
+	}
+
+	for _, test := range tests {
+		namespace, local := SplitServiceName(test.name)
+		if namespace != test.namespace || local != test.local {
+			t.Errorf("SplitServiceName(%q) = %q, %q, want %q, %q", test.name, namespace, local, test.namespace, test.local)
+		}
+		if canonical := CanonicalServiceName(test.name); canonical != test.canonical {
+			t.Errorf("CanonicalServiceName(%q) = %q, want %q", test.name, canonical, test.canonical)
+		}
+		if err := ValidateServiceName(test.name); (err == nil) != test.valid {
+			t.Errorf("ValidateServiceName(%q) = %v", test.name, err)
+		}
+	}
+}
+
+func TestNamespaceQuota(t *testing.T) {
+	defer func(quota int, quotas map[string]int) { DEFAULT_NAMESPACE_QUOTA, NAMESPACE_QUOTAS = quota, quotas }(DEFAULT_NAMESPACE_QUOTA, NAMESPACE_QUOTAS)
+	DEFAULT_NAMESPACE_QUOTA, NAMESPACE_QUOTAS = 2, map[string]int{"team-a": 1, "team-b": 0}
+	defer func() { services = make(map[string][]ServiceInfoAddress) }()
+	services = map[string][]ServiceInfoAddress{
+		"random":        {{Address: "10.0.0.2:4000", Info: ServiceInfo{Name: "random", Version: "1.0.0"}}},
+		"isprime":       {{Address: "10.0.0.2:4001", Info: ServiceInfo{Name: "isprime", Version: "1.0.0"}}},
+		"team-a/random": {{Address: "10.0.0.3:4000", Info: ServiceInfo{Name: "team-a/random", Version: "1.0.0"}}},
+		"team-b/random": {{Address: "10.0.0.4:4000", Info: ServiceInfo{Name: "team-b/random", Version: "1.0.0"}}},
+	}
+	tests := []struct {
+		name     string
+		address  string
+		version  string
+		accepted bool
+	}{
+		{"random", "10.0.0.5:4000", "1.0.0", false},
+		{"calculator", "10.0.0.5:4000", "1.0.0", false},
+		{"random", "10.0.0.2:4000", "1.1.0", true},
+		{"random", "10.0.0.2:4002", "1.0.0", true},
+		{"team-a/random", "10.0.0.5:4000", "1.0.0", false},
+		{"team-a/isprime", "10.0.0.3:4001", "1.0.0", false},
+		{"team-a/random", "10.0.0.3:4001", "1.0.0", true},
+		{"team-b/random", "10.0.0.5:4000", "1.0.0", true},
+		{"team-c/random", "10.0.0.5:4000", "1.0.0", true},
+	}
+
+	for _, test := range tests {
+		err := checkNamespaceQuota(ServiceInfoAddress{Address: test.address, Info: ServiceInfo{Name: test.name, Version: test.version}})
+		if (err == nil) != test.accepted {
+			t.Errorf("checkNamespaceQuota(%s at %s, %s) = %v, want accepted %t", test.name, test.address, test.version, err, test.accepted)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Maps the argument/result types used in contracts to JSON Schema types.
+// Unknown types (e.g. "var") are described by an empty schema (any value).
+var schemaTypes = map[string]string{
+	"int":     "integer",
+	"integer": "integer",
+	"long":    "integer",
+	"float":   "number",
+	"double":  "number",
+	"number":  "number",
+	"bool":    "boolean",
+	"boolean": "boolean",
+	"string":  "string",
+}
+
+// Returns the JSON Schema of a single contract type.
+func typeSchema(contractType, description string) map[string]interface{} {
+	schema := map[string]interface{}{}
+	if schemaType, ok := schemaTypes[strings.ToLower(contractType)]; ok {
+		schema["type"] = schemaType
+	}
+	if description != "" {
+		schema["description"] = description
+	}
+	return schema
+}
+
+// Returns the JSON Schema of the arguments of a service, as an object
+// with one required property per argument (named by ArgumentInfo.Name).
+// This is the request body accepted by the gateway.
+func argumentsSchema(info *ServiceInfo) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, argument := range contractArguments(info) {
+		properties[argument.Name] = typeSchema(argument.Type, argument.Description)
+		required = append(required, argument.Name)
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// Returns the JSON Schema of a ServiceResult of the service. The result
+// is always transferred as string, whatever the ResultType is.
+func resultSchema(info *ServiceInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"Result": typeSchema("string", "result of type "+info.ResultType),
+		},
+		"required": []string{"Result"},
+	}
+}
+
+// Returns a JSON Schema document for the contract of a service. The schema
+// describes the arguments object; the result is defined in "$defs".
+func ContractJSONSchema(info *ServiceInfo) map[string]interface{} {
+	schema := argumentsSchema(info)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = info.Name
+	schema["description"] = info.Description
+	schema["version"] = info.Version
+	schema["x-idempotent"] = info.Idempotent
+	schema["x-streaming"] = info.Streaming
+	schema["x-interactive"] = info.Interactive
+	schema["$defs"] = map[string]interface{}{"result": resultSchema(info)}
+	return schema
+}
+
+// Returns the name of a service as OpenAPI component name.
+func componentName(name string) string {
+	return strings.Replace(name, NAMESPACE_SEPARATOR, ".", -1)
+}
+
+// Returns an OpenAPI 3 document for the given services (mapping from
+// service name to contract), describing the paths of the gateway.
+// Streaming and interactive services are omitted, because the gateway
+// can't call them with a single request.
+func OpenAPIDocument(services map[string]ServiceInfo, serverURL string) map[string]interface{} {
+	errorResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+				},
+			},
+		}
+	}
+
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"Error": map[string]interface{}{"type": "string"}},
+		},
+	}
+	paths := map[string]interface{}{
+		"/services": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary":   "List all services with their contracts",
+				"responses": map[string]interface{}{"200": map[string]interface{}{"description": "contracts by service name"}},
+			},
+		},
+	}
+
+	names := []string{}
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		info := services[name]
+		if info.Streaming || info.Interactive {
+			continue
+		}
+		component := componentName(name)
+		schemas[component+".Arguments"] = argumentsSchema(&info)
+		schemas[component+".Result"] = resultSchema(&info)
+
+		paths["/services/"+name] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId":  component,
+				"x-idempotent": info.Idempotent,
+				"summary":      info.Description,
+				"parameters": []interface{}{
+					map[string]interface{}{
+						"name":        "version",
+						"in":          "query",
+						"required":    false,
+						"description": "version constraint, e.g. ^" + info.Version,
+						"schema":      map[string]interface{}{"type": "string"},
+					},
+				},
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{"$ref": "#/components/schemas/" + component + ".Arguments"},
+						},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "result of the service",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]interface{}{"$ref": "#/components/schemas/" + component + ".Result"},
+							},
+						},
+					},
+					"400": errorResponse("invalid arguments"),
+					"404": errorResponse("service or version not found"),
+					"429": errorResponse("rate limit of the service exceeded"),
+					"500": errorResponse("internal error of the service"),
+					"502": errorResponse("service call failed"),
+					"503": errorResponse("service overloaded or circuit breaker open"),
+				},
+			},
+		}
+	}
+
+	document := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Services",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+	if serverURL != "" {
+		document["servers"] = []interface{}{map[string]interface{}{"url": serverURL}}
+	}
+	return document
+}
+
+// Returns the contracts of all known services (highest version each).
+// The caller must hold servicesLock.
+func knownContracts(request *http.Request) map[string]ServiceInfo {
+	contracts := make(map[string]ServiceInfo)
+	for name, instances := range knownServices(requestLocalIP(request)) {
+		instance, _, _ := selectInstance(instances, "")
+		contracts[name] = instance.Info
+	}
+	return contracts
+}
+
+// GET /openapi.json returns the OpenAPI document of all services
+// (?server=http://gateway:8081 sets the server URL).
+func handleHTTPOpenAPI(writer http.ResponseWriter, request *http.Request) {
+	servicesLock.Lock()
+	contracts := knownContracts(request)
+	servicesLock.Unlock()
+
+	writeJSON(writer, http.StatusOK, OpenAPIDocument(contracts, request.URL.Query().Get("server")))
+}
+
+// GET /schemas/{name} returns the JSON Schema of a service contract.
+func handleHTTPSchema(writer http.ResponseWriter, request *http.Request) {
+	name := CanonicalServiceName(strings.TrimPrefix(request.URL.Path, "/schemas/"))
+
+	servicesLock.Lock()
+	info, ok := knownContracts(request)[name]
+	servicesLock.Unlock()
+
+	if !ok {
+		writeJSONError(writer, http.StatusNotFound, "service not found")
+		return
+	}
+	writeJSON(writer, http.StatusOK, ContractJSONSchema(&info))
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+)
+
+var (
+	// If true, a panic in a handler crashes the service (e.g. for
+	// debugging) instead of being answered with SERVICE_INTERNAL. Set by the
+	// environment variable HTW_CRASH_ON_PANIC.
+	CRASH_ON_PANIC = os.Getenv("HTW_CRASH_ON_PANIC") != ""
+)
+
+const (
+	// The handler of the service panicked. The service keeps running.
+	SERVICE_INTERNAL = "internal"
+)
+
+// Invokes the handler of the service.
+func (runtime *serviceRuntime) invoke(servicecall *ServiceCall) (result ServiceResult) {
+	defer runtime.recoverPanic(&result.Error)
+	return ServiceResult{Result: runtime.handler(servicecall)}
+}
+
+// Invokes the handler of a streaming service and returns the final result
+// of the stream.
+func (runtime *serviceRuntime) invokeStream(ctx context.Context, servicecall *ServiceCall, send StreamSender) (result ServiceResult) {
+	defer runtime.recoverPanic(&result.Error)
+	result.Error = handlerError(runtime.stream(ctx, servicecall, send))
+	return result
+}
+
+// Invokes the handler of an interactive service and returns its error.
+func (runtime *serviceRuntime) invokeSession(ctx context.Context, servicecall *ServiceCall, session *Session) (err *ServiceError) {
+	defer runtime.recoverPanic(&err)
+	return handlerError(runtime.session(ctx, servicecall, session))
+}
+
+// Returns the error of a handler as ServiceError: SERVICE_FAILED unless
+// it's already a ServiceError.
+func handlerError(err error) *ServiceError {
+	if serviceError, ok := err.(*ServiceError); ok {
+		return serviceError
+	} else if err != nil {
+		return &ServiceError{SERVICE_FAILED, err.Error()}
+	}
+	return nil
+}
+
+// Recovers a panic of a handler, logs it with its stack trace and sets err
+// to a SERVICE_INTERNAL error, unless CRASH_ON_PANIC is set. Must be
+// deferred.
+func (runtime *serviceRuntime) recoverPanic(err **ServiceError) {
+	if CRASH_ON_PANIC {
+		return
+	}
+	if recovered := recover(); recovered != nil {
+		fmt.Printf("panic in service %s %s: %v\n%s", runtime.info.Name, runtime.info.Version, recovered, debug.Stack())
+		*err = &ServiceError{SERVICE_INTERNAL, fmt.Sprint("panic: ", recovered)}
+	}
+}
@@ -0,0 +1,328 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Whether calls use persistent connections to the services, on which
+	// concurrent calls are multiplexed (identified by ServiceCall.ID).
+	CONNECTION_POOLING = true
+	// Maximum number of persistent connections per service address.
+	POOL_SIZE = 2
+	// Persistent connections unused for this duration are closed by the
+	// client.
+	POOL_IDLE_TIMEOUT = time.Minute
+	// Persistent connections without calls for this duration are closed by
+	// the service. Should be longer than POOL_IDLE_TIMEOUT.
+	SERVICE_IDLE_TIMEOUT = 5 * time.Minute
+	// Maximum number of calls a service processes at the same time on a
+	// single persistent connection. Further calls are read from the
+	// connection when one of them is done.
+	MAX_MULTIPLEXED_CALLS = 128
+	// Persistent connections of the client.
+	pool = connectionPool{connections: make(map[string][]*pooledConnection), legacy: make(map[string]bool)}
+	// Last ID of a call on a persistent connection.
+	lastCallID uint64
+	// Returned by connectionPool.call() if the call wasn't processed on a
+	// persistent connection, because the service doesn't support them or
+	// all connections still wait for their first result. The call has to
+	// be sent on a connection of its own.
+	errNotPooled = errors.New("error: call not sent on a persistent connection")
+)
+
+// Serves further calls on a connection after a call with ID (first). Calls
+// are processed concurrently and answered in any order; the results carry
+// the ID of their call.
+func serveMultiplexed(connection *net.TCPConn, decoder *json.Decoder, runtime *serviceRuntime, first ServiceCall) error {
+	encoder := json.NewEncoder(connection)
+	writeLock := sync.Mutex{}
+	calls := sync.WaitGroup{}
+	defer calls.Wait()
+	slots := make(chan struct{}, MAX_MULTIPLEXED_CALLS)
+
+	answer := func(servicecall ServiceCall) {
+		defer calls.Done()
+		defer func() { <-slots }()
+		result := runtime.call(&servicecall)
+		result.ID = servicecall.ID
+		writeLock.Lock()
+		encoder.Encode(result)
+		writeLock.Unlock()
+	}
+
+	slots <- struct{}{}
+	calls.Add(1)
+	go answer(first)
+	for {
+		slots <- struct{}{}
+		connection.SetReadDeadline(time.Now().Add(SERVICE_IDLE_TIMEOUT))
+		servicecall := ServiceCall{}
+		err := decoder.Decode(&servicecall)
+		if err != nil {
+			<-slots
+			return err
+		}
+		servicecall.Caller = connection.RemoteAddr().String()
+		calls.Add(1)
+		go answer(servicecall)
+	}
+}
+
+// A persistent connection of the client to a service. Until the first
+// result with ID arrives, the service may not support persistent
+// connections, so only the first call is sent on it.
+type pooledConnection struct {
+	connection net.Conn
+	encoder    *json.Encoder
+	// guards writes to the connection
+	writeLock sync.Mutex
+	// guards the following fields
+	lock sync.Mutex
+	// channels of the calls waiting for their result by ID
+	pending  map[uint64]chan ServiceResult
+	lastUsed time.Time
+	// ID of the first call sent on the connection
+	first uint64
+	// whether the service answered a call with its ID
+	confirmed bool
+	// error which closed the connection
+	err error
+}
+
+// Persistent connections by service address.
+type connectionPool struct {
+	lock        sync.Mutex
+	connections map[string][]*pooledConnection
+	// addresses of services which don't support persistent connections
+	legacy map[string]bool
+}
+
+// Returns true if the service at the address doesn't support persistent
+// connections.
+func (pool *connectionPool) isLegacy(address string) bool {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return pool.legacy[address]
+}
+
+// Marks the service at the address as not supporting persistent
+// connections.
+func (pool *connectionPool) setLegacy(address string) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	pool.legacy[address] = true
+}
+
+// Returns a connection to the address: a new one if there are less than
+// POOL_SIZE connections, otherwise the confirmed one with the fewest
+// pending calls. New connections are dialed without holding the lock of
+// the pool and are returned for a single call until they are confirmed.
+// Returns errNotPooled if there is no connection for the call.
+func (pool *connectionPool) get(ctx context.Context, address string) (*pooledConnection, error) {
+	pool.lock.Lock()
+	best, bestPending, count := pool.leastPending(address)
+	pool.lock.Unlock()
+	if best != nil && (bestPending == 0 || count >= POOL_SIZE) {
+		return best, nil
+	}
+	if count >= POOL_SIZE {
+		return nil, errNotPooled
+	}
+
+	dialer := net.Dialer{}
+	connection, err := dialer.DialContext(ctx, TCP_PROTOCOL, address)
+	if err != nil {
+		return nil, err
+	}
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	best, _, count = pool.leastPending(address)
+	if count >= POOL_SIZE {
+		// other calls added connections in the meantime
+		connection.Close()
+		if best != nil {
+			return best, nil
+		}
+		return nil, errNotPooled
+	}
+	pooled := &pooledConnection{
+		connection: connection,
+		encoder:    json.NewEncoder(connection),
+		pending:    make(map[uint64]chan ServiceResult),
+		lastUsed:   time.Now(),
+	}
+	pool.connections[address] = append(pool.connections[address], pooled)
+	go pooled.receive(address)
+	return pooled, nil
+}
+
+// Removes closed and idle connections to the address and returns the
+// confirmed one with the fewest pending calls, its number of pending calls
+// and the number of connections. The caller must hold the lock of the pool.
+func (pool *connectionPool) leastPending(address string) (*pooledConnection, int, int) {
+	var best *pooledConnection
+	bestPending := 0
+	kept := []*pooledConnection{}
+	for _, connection := range pool.connections[address] {
+		connection.lock.Lock()
+		pending, idle := len(connection.pending), connection.err == nil && time.Since(connection.lastUsed) > POOL_IDLE_TIMEOUT
+		closed, confirmed := connection.err != nil, connection.confirmed
+		connection.lock.Unlock()
+
+		if idle && pending == 0 {
+			connection.close(errors.New("error: connection idle"))
+			continue
+		}
+		if closed {
+			continue
+		}
+		kept = append(kept, connection)
+		if confirmed && (best == nil || pending < bestPending) {
+			best, bestPending = connection, pending
+		}
+	}
+	pool.connections[address] = kept
+	return best, bestPending, len(kept)
+}
+
+// Invokes a call on a persistent connection to the address and waits for
+// its result. Returns errNotPooled if the call wasn't processed on a
+// persistent connection.
+func (pool *connectionPool) call(ctx context.Context, address string, servicecall ServiceCall) (ServiceResult, error) {
+	connection, err := pool.get(ctx, address)
+	if err != nil {
+		return ServiceResult{}, err
+	}
+
+	servicecall.ID = atomic.AddUint64(&lastCallID, 1)
+	result := make(chan ServiceResult, 1)
+	err = connection.send(ctx, servicecall, result)
+	if err != nil && pool.isLegacy(address) {
+		return ServiceResult{}, errNotPooled
+	}
+	if err != nil {
+		return ServiceResult{}, contextError(ctx, err)
+	}
+
+	select {
+	case serviceresult, ok := <-result:
+		if !ok {
+			if pool.isLegacy(address) {
+				return ServiceResult{}, errNotPooled
+			}
+			return ServiceResult{}, connection.closeError()
+		}
+		return serviceresult, nil
+	case <-ctx.Done():
+		connection.lock.Lock()
+		delete(connection.pending, servicecall.ID)
+		connection.lock.Unlock()
+		return ServiceResult{}, ctx.Err()
+	}
+}
+
+// Sends a call, whose result is delivered to the given channel. The channel
+// is closed if the connection is closed before.
+func (connection *pooledConnection) send(ctx context.Context, servicecall ServiceCall, result chan ServiceResult) error {
+	connection.lock.Lock()
+	if connection.err != nil {
+		connection.lock.Unlock()
+		return connection.err
+	}
+	connection.pending[servicecall.ID] = result
+	connection.lastUsed = time.Now()
+	if connection.first == 0 {
+		connection.first = servicecall.ID
+	}
+	connection.lock.Unlock()
+
+	connection.writeLock.Lock()
+	deadline, _ := ctx.Deadline()
+	connection.connection.SetWriteDeadline(deadline)
+	err := connection.encoder.Encode(servicecall)
+	connection.writeLock.Unlock()
+	if err != nil {
+		connection.lock.Lock()
+		delete(connection.pending, servicecall.ID)
+		connection.lock.Unlock()
+		connection.close(err)
+		return err
+	}
+	return nil
+}
+
+// Receives results and delivers them to the waiting calls until the
+// connection is closed. A service which answers the first call without ID
+// or closes the connection before answering it doesn't support persistent
+// connections (it didn't process the call then).
+func (connection *pooledConnection) receive(address string) {
+	decoder := json.NewDecoder(connection.connection)
+	for {
+		serviceresult := ServiceResult{}
+		err := decoder.Decode(&serviceresult)
+		if err != nil {
+			connection.lock.Lock()
+			legacy := connection.err == nil && !connection.confirmed
+			connection.lock.Unlock()
+			if legacy {
+				pool.setLegacy(address)
+			}
+			connection.close(err)
+			return
+		}
+
+		legacy := serviceresult.ID == 0
+		connection.lock.Lock()
+		if legacy {
+			// the service answered the first call, which is the only one
+			// sent before the connection is confirmed
+			serviceresult.ID = connection.first
+		} else {
+			connection.confirmed = true
+		}
+		result, ok := connection.pending[serviceresult.ID]
+		delete(connection.pending, serviceresult.ID)
+		connection.lastUsed = time.Now()
+		connection.lock.Unlock()
+
+		if ok {
+			result <- serviceresult
+		}
+		if legacy {
+			pool.setLegacy(address)
+			connection.close(errNotPooled)
+			return
+		}
+	}
+}
+
+// Closes the connection and fails all pending calls.
+func (connection *pooledConnection) close(err error) {
+	connection.lock.Lock()
+	defer connection.lock.Unlock()
+
+	if connection.err != nil {
+		return
+	}
+	connection.err = errors.New("error: connection closed: " + err.Error())
+	connection.connection.Close()
+	for id, result := range connection.pending {
+		close(result)
+		delete(connection.pending, id)
+	}
+}
+
+// Returns the error which closed the connection.
+func (connection *pooledConnection) closeError() error {
+	connection.lock.Lock()
+	defer connection.lock.Unlock()
+	return connection.err
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Starts a service with the handler on a free port and returns its
+// address. Every call records the address of its caller.
+func startPoolService(t *testing.T, handler ServiceHandler) (*net.TCPAddr, func() map[string]bool) {
+	listener, err := net.ListenTCP(TCP_PROTOCOL, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	callersLock := sync.Mutex{}
+	callers := make(map[string]bool)
+	runtime := &serviceRuntime{info: &ServiceInfo{Name: "echo"}, handler: func(servicecall *ServiceCall) string {
+		callersLock.Lock()
+		callers[servicecall.Caller] = true
+		callersLock.Unlock()
+		return handler(servicecall)
+	}}
+
+	go func() {
+		for {
+			connection, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			go handleServiceConnection(connection, runtime)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().(*net.TCPAddr), func() map[string]bool {
+		callersLock.Lock()
+		defer callersLock.Unlock()
+		return callers
+	}
+}
+
+// Starts a service like those without persistent connections: it reads a
+// single call with one Read and closes the connection after the result.
+func startLegacyService(t *testing.T) *net.TCPAddr {
+	listener, err := net.ListenTCP(TCP_PROTOCOL, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			connection, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer connection.Close()
+				buffer := make([]byte, PACKET_SIZE)
+				n, err := connection.Read(buffer)
+				servicecall := ServiceCall{}
+				if err != nil || json.Unmarshal(buffer[:n], &servicecall) != nil {
+					return
+				}
+				bytes, _ := json.Marshal(struct{ Result string }{"legacy " + servicecall.Arguments[0]})
+				connection.Write(bytes)
+			}()
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().(*net.TCPAddr)
+}
+
+// Invokes n concurrent calls and returns the first error.
+func concurrentCalls(address *net.TCPAddr, n int, result func(i int) string) error {
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			value, err := CallServiceAddress(address, "echo", strconv.Itoa(i))
+			if err == nil && value != result(i) {
+				err = &ServiceError{"wrong_result", value}
+			}
+			errs <- err
+		}(i)
+	}
+	var first error
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func TestPoolPipelining(t *testing.T) {
+	address, callers := startPoolService(t, func(servicecall *ServiceCall) string {
+		time.Sleep(time.Millisecond)
+		return servicecall.Arguments[0]
+	})
+
+	if err := concurrentCalls(address, 50, strconv.Itoa); err != nil {
+		t.Fatal(err)
+	}
+	before := len(callers())
+	if err := concurrentCalls(address, 50, strconv.Itoa); err != nil {
+		t.Fatal(err)
+	}
+	if pool.isLegacy(address.String()) {
+		t.Error("service marked as legacy")
+	}
+	// once the connections are confirmed, all calls are pipelined on them
+	if after := len(callers()); after != before {
+		t.Errorf("calls on %d connections, %d before, want the pooled connections only", after, before)
+	}
+	pool.lock.Lock()
+	connections := len(pool.connections[address.String()])
+	pool.lock.Unlock()
+	if connections > POOL_SIZE {
+		t.Errorf("%d pooled connections, want at most %d", connections, POOL_SIZE)
+	}
+}
+
+func TestPoolLegacyFallback(t *testing.T) {
+	address := startLegacyService(t)
+
+	err := concurrentCalls(address, 50, func(i int) string { return "legacy " + strconv.Itoa(i) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pool.isLegacy(address.String()) {
+		t.Error("service not marked as legacy")
+	}
+}
+
+func TestPoolCancel(t *testing.T) {
+	release := make(chan struct{})
+	address, _ := startPoolService(t, func(servicecall *ServiceCall) string {
+		if servicecall.Arguments[0] == "block" {
+			<-release
+		}
+		return servicecall.Arguments[0]
+	})
+	if _, err := CallServiceAddress(address, "echo", "confirm"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := CallServiceAddressContext(ctx, address, "echo", "block"); err != context.DeadlineExceeded {
+		t.Errorf("CallServiceAddressContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the connection keeps working and drops the late result
+	if result, err := CallServiceAddress(address, "echo", "next"); err != nil || result != "next" {
+		t.Errorf("CallServiceAddress() = %q, %v after a canceled call", result, err)
+	}
+	close(release)
+	time.Sleep(10 * time.Millisecond)
+	pool.lock.Lock()
+	connections := pool.connections[address.String()]
+	pool.lock.Unlock()
+	for _, connection := range connections {
+		connection.lock.Lock()
+		pending := len(connection.pending)
+		connection.lock.Unlock()
+		if pending != 0 {
+			t.Errorf("%d pending calls after the canceled call", pending)
+		}
+	}
+}
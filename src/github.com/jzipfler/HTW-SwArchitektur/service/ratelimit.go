@@ -0,0 +1,196 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Token bucket rate limit: Rate calls per second on average, with bursts
+// of up to Burst calls. A zero Rate means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+var (
+	// Rate limits of services (by service name) for all callers together.
+	RATE_LIMITS = make(map[string]RateLimit)
+	// Rate limit of each caller of a service, unless the caller has a limit
+	// in CALLER_RATE_LIMITS.
+	CALLER_RATE_LIMIT = RateLimit{}
+	// Rate limits of individual callers (by identity, see CALLER_IDENTITY),
+	// e.g. a higher quota for a batch client.
+	CALLER_RATE_LIMITS = make(map[string]RateLimit)
+	// Returns the identity of the caller of a service, which its rate limit
+	// is applied to. By default the IP address of the caller; services which
+	// authenticate callers (e.g. by a token in Metadata) can return the
+	// principal instead.
+	CALLER_IDENTITY = func(servicecall *ServiceCall) string {
+		host, _, err := net.SplitHostPort(servicecall.Caller)
+		if err != nil {
+			return servicecall.Caller
+		}
+		return host
+	}
+	// Rate limit of lookups ("address", "info", "list" and "instances") at
+	// the registry for each client IP address.
+	LOOKUP_RATE_LIMIT = RateLimit{}
+	// Token buckets of the rate limits.
+	rateLimiter = rateLimiterMap{buckets: make(map[rateLimitKey]*tokenBucket)}
+)
+
+const (
+	// The call exceeded the rate limit of the service or of the caller. The
+	// call wasn't executed.
+	SERVICE_RATE_LIMITED = "rate_limited"
+	// Interval in which buckets which are full again are removed.
+	RATE_LIMIT_CLEANUP = time.Minute
+)
+
+// Identifies a token bucket: the bucket of a service for all callers (empty
+// caller), of a caller of a service, or of a client of the registry (empty
+// service).
+type rateLimitKey struct {
+	service string
+	caller  string
+}
+
+// Tokens left in a bucket at the given time.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit
+}
+
+// The limit of the token bucket with the key.
+type bucketLimit struct {
+	key   rateLimitKey
+	limit RateLimit
+}
+
+// Token buckets by key.
+type rateLimiterMap struct {
+	lock    sync.Mutex
+	buckets map[rateLimitKey]*tokenBucket
+	cleaned time.Time
+}
+
+// Refills the bucket up to the burst size.
+func (bucket *tokenBucket) refill(now time.Time) {
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.limit.Rate
+	bucket.tokens = math.Min(bucket.tokens, math.Max(float64(bucket.limit.Burst), 1))
+	bucket.last = now
+}
+
+// Takes a token from the bucket with the given key. Returns zero if a token
+// was available, otherwise the time until the next one.
+func (limiter *rateLimiterMap) take(key rateLimitKey, limit RateLimit) time.Duration {
+	_, wait := limiter.takeAll(bucketLimit{key, limit})
+	return wait
+}
+
+// Takes a token from each of the given buckets, or from none of them if
+// one is empty. Returns zero if the tokens were available, otherwise the
+// index of the first empty bucket and the time until its next token.
+func (limiter *rateLimiterMap) takeAll(limits ...bucketLimit) (int, time.Duration) {
+	now := time.Now()
+
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	if now.Sub(limiter.cleaned) >= RATE_LIMIT_CLEANUP {
+		for key, bucket := range limiter.buckets {
+			bucket.refill(now)
+			if bucket.tokens >= math.Max(float64(bucket.limit.Burst), 1) {
+				delete(limiter.buckets, key)
+			}
+		}
+		limiter.cleaned = now
+	}
+
+	buckets := make([]*tokenBucket, 0, len(limits))
+	for i, limit := range limits {
+		if limit.limit.Rate <= 0 {
+			continue
+		}
+		bucket, ok := limiter.buckets[limit.key]
+		if !ok || bucket.limit != limit.limit {
+			bucket = &tokenBucket{tokens: math.Max(float64(limit.limit.Burst), 1), last: now, limit: limit.limit}
+			limiter.buckets[limit.key] = bucket
+		}
+		bucket.refill(now)
+		if bucket.tokens < 1 {
+			return i, time.Duration((1 - bucket.tokens) / limit.limit.Rate * float64(time.Second))
+		}
+		buckets = append(buckets, bucket)
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return 0, 0
+}
+
+// Returns the rate limit error for a limit which was exceeded.
+func rateLimitError(limit RateLimit, wait time.Duration, scope string) *ServiceError {
+	message := fmt.Sprintf("%s exceeds %g calls/s, retry in %v", scope, limit.Rate, wait.Round(time.Millisecond))
+	return &ServiceError{SERVICE_RATE_LIMITED, message}
+}
+
+// Checks the rate limits of a call of a service: the limit of the caller
+// first, so that a single caller doesn't exhaust the limit of the service.
+// Returns a SERVICE_RATE_LIMITED error if one is exceeded.
+func checkRateLimits(info *ServiceInfo, servicecall *ServiceCall) *ServiceError {
+	name := CanonicalServiceName(info.Name)
+	caller := CALLER_IDENTITY(servicecall)
+
+	limit, ok := CALLER_RATE_LIMITS[caller]
+	if !ok {
+		limit = CALLER_RATE_LIMIT
+	}
+	limits := []bucketLimit{{rateLimitKey{name, caller}, limit}, {rateLimitKey{name, ""}, RATE_LIMITS[name]}}
+	// a call which is rejected by one limit doesn't count against the other
+	switch index, wait := rateLimiter.takeAll(limits...); {
+	case wait == 0:
+		return nil
+	case index == 0:
+		return rateLimitError(limits[0].limit, wait, "caller "+caller)
+	default:
+		return rateLimitError(limits[1].limit, wait, "service "+info.Name)
+	}
+}
+
+// Checks LOOKUP_RATE_LIMIT for a lookup at the registry by the given
+// client. Returns the JSON error response if it's exceeded.
+func checkLookupRateLimit(operation string, address net.Addr) ([]byte, bool) {
+	switch operation {
+	case OPERATION_ADDRESS, OPERATION_INFO, OPERATION_LIST, OPERATION_INSTANCES:
+	default:
+		return nil, false
+	}
+	host, _, _ := net.SplitHostPort(address.String())
+	wait := rateLimiter.take(rateLimitKey{"", host}, LOOKUP_RATE_LIMIT)
+	if wait == 0 {
+		return nil, false
+	}
+	bytes, _ := json.Marshal(lookupError{rateLimitError(LOOKUP_RATE_LIMIT, wait, "client "+host)})
+	return bytes, true
+}
+
+// Response of the registry to a lookup which it refused.
+type lookupError struct {
+	Error *ServiceError
+}
+
+// Returns the error of a lookup response, if the registry refused the
+// lookup.
+func lookupResponseError(response []byte) error {
+	refused := lookupError{}
+	if json.Unmarshal(response, &refused) == nil && refused.Error != nil && refused.Error.Code != "" {
+		return refused.Error
+	}
+	return nil
+}
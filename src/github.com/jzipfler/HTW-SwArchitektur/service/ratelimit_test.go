@@ -0,0 +1,129 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	tests := []struct {
+		limit   RateLimit
+		tokens  float64
+		elapsed time.Duration
+		result  float64
+	}{
+		{RateLimit{10, 5}, 0, 100 * time.Millisecond, 1},
+		{RateLimit{10, 5}, 0, 250 * time.Millisecond, 2.5},
+		{RateLimit{10, 5}, 4, time.Second, 5},
+		{RateLimit{0.5, 0}, 0, time.Second, 0.5},
+		{RateLimit{0.5, 0}, 0.5, time.Hour, 1},
+		{RateLimit{2, 3}, 3, 0, 3},
+	}
+
+	for _, test := range tests {
+		start := time.Now()
+		bucket := &tokenBucket{tokens: test.tokens, last: start, limit: test.limit}
+		bucket.refill(start.Add(test.elapsed))
+		if bucket.tokens != test.result || !bucket.last.Equal(start.Add(test.elapsed)) {
+			t.Errorf("refill(%v) of %v with %g tokens = %g, want %g", test.elapsed, test.limit, test.tokens, bucket.tokens, test.result)
+		}
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	tests := []struct {
+		limit  RateLimit
+		calls  int
+		passed int
+	}{
+		{RateLimit{}, 100, 100},
+		{RateLimit{1, 5}, 10, 5},
+		{RateLimit{1, 0}, 3, 1},
+		{RateLimit{0.001, 1}, 2, 1},
+	}
+
+	for _, test := range tests {
+		limiter := rateLimiterMap{buckets: make(map[rateLimitKey]*tokenBucket)}
+		passed := 0
+		var wait time.Duration
+		for i := 0; i < test.calls; i++ {
+			if wait = limiter.take(rateLimitKey{"isprime", "10.0.0.2"}, test.limit); wait == 0 {
+				passed++
+			}
+		}
+		if passed != test.passed {
+			t.Errorf("take() with %v passed %d of %d calls, want %d", test.limit, passed, test.calls, test.passed)
+		}
+		if passed < test.calls && (wait <= 0 || wait > time.Duration(float64(time.Second)/test.limit.Rate)) {
+			t.Errorf("take() with %v = %v, want the time until the next token", test.limit, wait)
+		}
+	}
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	limiter := rateLimiterMap{buckets: make(map[rateLimitKey]*tokenBucket)}
+	limit := RateLimit{1, 1}
+
+	if limiter.take(rateLimitKey{"isprime", "10.0.0.2"}, limit) != 0 {
+		t.Fatal("take() rejected the first call")
+	}
+	if limiter.take(rateLimitKey{"isprime", "10.0.0.2"}, limit) == 0 {
+		t.Error("take() passed a call beyond the burst")
+	}
+	// other callers and services have buckets of their own
+	if limiter.take(rateLimitKey{"isprime", "10.0.0.3"}, limit) != 0 {
+		t.Error("take() rejected the call of another caller")
+	}
+	if limiter.take(rateLimitKey{"random", "10.0.0.2"}, limit) != 0 {
+		t.Error("take() rejected the call of another service")
+	}
+	// a changed limit starts with a full bucket
+	if limiter.take(rateLimitKey{"isprime", "10.0.0.2"}, RateLimit{1, 2}) != 0 {
+		t.Error("take() rejected a call after the limit changed")
+	}
+}
+
+func TestCheckRateLimits(t *testing.T) {
+	defer func(limits map[string]RateLimit, limit RateLimit) { RATE_LIMITS, CALLER_RATE_LIMIT = limits, limit }(RATE_LIMITS, CALLER_RATE_LIMIT)
+	defer func() { rateLimiter = rateLimiterMap{buckets: make(map[rateLimitKey]*tokenBucket)} }()
+	rateLimiter = rateLimiterMap{buckets: make(map[rateLimitKey]*tokenBucket)}
+	info := &ServiceInfo{Name: "isprime"}
+	CALLER_RATE_LIMIT = RateLimit{0.001, 3}
+	tests := []struct {
+		serviceLimit RateLimit
+		caller       string
+		rejected     string
+	}{
+		{RateLimit{0.001, 2}, "10.0.0.2:4000", ""},
+		{RateLimit{0.001, 2}, "10.0.0.3:4000", ""},
+		// rejected by the service, the caller keeps its tokens
+		{RateLimit{0.001, 2}, "10.0.0.2:4000", "service"},
+		{RateLimit{0.001, 2}, "10.0.0.2:4000", "service"},
+		// a changed limit starts with a full bucket
+		{RateLimit{0.001, 10}, "10.0.0.2:4000", ""},
+		{RateLimit{0.001, 10}, "10.0.0.2:4000", ""},
+		// rejected by the caller limit, the service keeps its tokens
+		{RateLimit{0.001, 10}, "10.0.0.2:4000", "caller"},
+		{RateLimit{0.001, 10}, "10.0.0.3:4000", ""},
+		{RateLimit{0.001, 10}, "10.0.0.3:4000", ""},
+		{RateLimit{0.001, 10}, "10.0.0.4:4000", ""},
+		{RateLimit{0.001, 10}, "10.0.0.4:4000", ""},
+		{RateLimit{0.001, 10}, "10.0.0.4:4000", ""},
+		{RateLimit{0.001, 10}, "10.0.0.5:4000", ""},
+		{RateLimit{0.001, 10}, "10.0.0.5:4000", ""},
+		{RateLimit{0.001, 10}, "10.0.0.5:4000", ""},
+		{RateLimit{0.001, 10}, "10.0.0.6:4000", "service"},
+	}
+
+	for i, test := range tests {
+		RATE_LIMITS = map[string]RateLimit{"isprime": test.serviceLimit}
+		err := checkRateLimits(info, &ServiceCall{Name: "isprime", Caller: test.caller})
+		switch {
+		case test.rejected == "" && err != nil:
+			t.Errorf("call %d by %s rejected: %v", i, test.caller, err)
+		case test.rejected != "" && (err == nil || err.Code != SERVICE_RATE_LIMITED || !strings.HasPrefix(err.Message, test.rejected)):
+			t.Errorf("call %d by %s = %v, want rejected by the %s limit", i, test.caller, err, test.rejected)
+		}
+	}
+}
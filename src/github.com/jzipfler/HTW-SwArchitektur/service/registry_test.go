@@ -0,0 +1,44 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegisterInstance(t *testing.T) {
+	defer func() { services = make(map[string][]ServiceInfoAddress) }()
+	services = make(map[string][]ServiceInfoAddress)
+	register := func(address, version string) {
+		registerInstance(ServiceInfoAddress{Address: address, Info: ServiceInfo{Name: "isprime", Version: version}})
+	}
+	tests := []struct {
+		name      string
+		address   string
+		version   string
+		addresses []string
+	}{
+		{"first", "10.0.0.2:4000", "1.0.0", []string{"10.0.0.2:4000"}},
+		{"same address", "10.0.0.2:4000", "1.0.0", []string{"10.0.0.2:4000"}},
+		{"restarted on another port", "10.0.0.2:4001", "1.0.0", []string{"10.0.0.2:4001"}},
+		{"other host", "10.0.0.3:4000", "1.0.0", []string{"10.0.0.2:4001", "10.0.0.3:4000"}},
+		{"other version", "10.0.0.2:4002", "1.1.0", []string{"10.0.0.2:4001", "10.0.0.3:4000", "10.0.0.2:4002"}},
+		{"upgrade at the same address", "10.0.0.3:4000", "1.1.0", []string{"10.0.0.2:4001", "10.0.0.2:4002", "10.0.0.3:4000"}},
+	}
+
+	for _, test := range tests {
+		register(test.address, test.version)
+		addresses := []string{}
+		for _, instance := range services["isprime"] {
+			addresses = append(addresses, instance.Address)
+		}
+		if !reflect.DeepEqual(addresses, test.addresses) {
+			t.Errorf("%s: instances %v, want %v", test.name, addresses, test.addresses)
+		}
+	}
+
+	// lookups after a restart return the new address
+	register("10.0.0.2:4003", "1.1.0")
+	if instance, _, _ := selectInstance(services["isprime"], "^1.1"); instance.Address != "10.0.0.2:4003" {
+		t.Errorf("selectInstance() after restart = %q, want %q", instance.Address, "10.0.0.2:4003")
+	}
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Class of the error of a failed call attempt, which determines whether
+// the call may be retried (see RetryPolicy.RetryOn).
+type ErrorClass int
+
+const (
+	// The service couldn't be looked up (registry unreachable or service
+	// not found).
+	ERROR_LOOKUP ErrorClass = 1 << iota
+	// The service couldn't be reached, so the call wasn't sent.
+	ERROR_CONNECT
+	// The connection was lost after the call was sent. Only calls of
+	// idempotent services are retried.
+	ERROR_CONNECTION
+	// The attempt exceeded RetryPolicy.AttemptTimeout. Only calls of
+	// idempotent services are retried.
+	ERROR_TIMEOUT
+	// The service rejected the call as overloaded (SERVICE_OVERLOADED), so
+	// the call wasn't executed.
+	ERROR_OVERLOADED
+)
+
+// Policy for retrying failed calls of CallService(). A retry prefers an
+// instance of the service which wasn't tried yet (failover).
+type RetryPolicy struct {
+	// Maximum number of attempts (1 = no retries).
+	MaxAttempts int
+	// Delay before the first retry, doubled (Multiplier) for every further
+	// retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Random deviation of the delays, e.g. 0.2 for +-20%.
+	Jitter float64
+	// Timeout of each attempt (0 = only the deadline of the context).
+	AttemptTimeout time.Duration
+	// Error classes which are retried.
+	RetryOn ErrorClass
+}
+
+var (
+	// Retry policy of all services without policy in RETRY_POLICIES.
+	DEFAULT_RETRY_POLICY = RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryOn:        ERROR_LOOKUP | ERROR_CONNECT | ERROR_CONNECTION | ERROR_TIMEOUT | ERROR_OVERLOADED,
+	}
+	// Retry policies by service name.
+	RETRY_POLICIES = make(map[string]RetryPolicy)
+	// Policy which doesn't retry.
+	NO_RETRY = RetryPolicy{MaxAttempts: 1}
+)
+
+// Returns the retry policy of a service.
+func retryPolicy(name string) RetryPolicy {
+	if policy, ok := RETRY_POLICIES[CanonicalServiceName(name)]; ok {
+		return policy
+	}
+	return DEFAULT_RETRY_POLICY
+}
+
+// Returns true if an attempt which failed with an error of the given class
+// may be retried. Errors after the call was sent are only retried for
+// idempotent services.
+func (policy *RetryPolicy) retryable(class ErrorClass, idempotent bool) bool {
+	if class&(ERROR_CONNECTION|ERROR_TIMEOUT) != 0 && !idempotent {
+		return false
+	}
+	return policy.RetryOn&class != 0
+}
+
+// Returns the delay before the given retry (1 = first retry).
+func (policy *RetryPolicy) backoff(retry int) time.Duration {
+	delay := float64(policy.InitialBackoff) * math.Pow(math.Max(policy.Multiplier, 1), float64(retry-1))
+	if policy.MaxBackoff > 0 {
+		delay = math.Min(delay, float64(policy.MaxBackoff))
+	}
+	delay *= 1 + policy.Jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
+
+// Returns the class of the error of a call attempt, or 0 if the error
+// isn't retryable at all (e.g. the context was canceled).
+func ClassifyError(err error) ErrorClass {
+	if err == nil || err == context.Canceled {
+		return 0
+	}
+	if err == context.DeadlineExceeded {
+		return ERROR_TIMEOUT
+	}
+	if serviceError, ok := err.(*ServiceError); ok {
+		if serviceError.Code == SERVICE_OVERLOADED {
+			return ERROR_OVERLOADED
+		}
+		return 0
+	}
+	if opError, ok := err.(*net.OpError); ok && opError.Op == "dial" {
+		return ERROR_CONNECT
+	}
+	if netError, ok := err.(net.Error); ok && netError.Timeout() {
+		return ERROR_TIMEOUT
+	}
+	return ERROR_CONNECTION
+}
+
+// Returns the instance for a call attempt: the highest matching version
+// which wasn't tried yet or, if all were tried, the instance the registry
+// hands out.
+func callInstance(name, constraint string, tried map[string]bool) (*ServiceInfoAddress, error) {
+	if len(tried) > 0 {
+		instances, err := GetServiceInstances(name)
+		if err == nil {
+			untried := []ServiceInfoAddress{}
+			for _, instance := range instances {
+				if !tried[instance.Address] {
+					untried = append(untried, instance)
+				}
+			}
+			if instance, found, _ := selectInstance(untried, constraint); found {
+				return &instance, nil
+			}
+		}
+	}
+
+	instance, err := GetServiceInfoVersion(name, constraint)
+	if err == nil && instance.Address == "" {
+		err = errors.New("error: service \"" + name + "\" not found!")
+	}
+	return instance, err
+}
+
+// Makes one call attempt at the given instance.
+func callAttempt(ctx context.Context, policy *RetryPolicy, instance *ServiceInfoAddress, servicecall *ServiceCall) (string, error) {
+	address, err := net.ResolveTCPAddr(TCP_PROTOCOL, instance.Address)
+	if err != nil {
+		return "", err
+	}
+	if policy.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.AttemptTimeout)
+		defer cancel()
+	}
+	return callAddress(ctx, address, *servicecall)
+}
+
+// Invokes a service according to its retry policy (see RETRY_POLICIES).
+func callServiceRetry(ctx context.Context, servicecall *ServiceCall, constraint string) (string, error) {
+	name := servicecall.Name
+	policy := retryPolicy(name)
+	tried := make(map[string]bool)
+
+	for attempt := 1; ; attempt++ {
+		class, idempotent := ERROR_LOOKUP, false
+		instance, err := callInstance(name, constraint, tried)
+		if err == nil {
+			ok, serviceOpen := breakers.allow(name, instance.Address)
+			switch {
+			case serviceOpen:
+				return "", ErrCircuitOpen
+			case !ok:
+				// the breaker of the instance is open, try another one
+				tried[instance.Address] = true
+				class, err = ERROR_CONNECT, ErrCircuitOpen
+			default:
+				var result string
+				result, err = callAttempt(ctx, &policy, instance, servicecall)
+				breakers.record(name, instance.Address, err)
+				if err == nil {
+					return result, nil
+				}
+				// the cached address may be outdated
+				InvalidateLookupCache(name)
+				tried[instance.Address] = true
+				class, idempotent = ClassifyError(err), instance.Info.Idempotent
+			}
+		}
+
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if attempt >= policy.MaxAttempts || !policy.retryable(class, idempotent) {
+			return "", err
+		}
+
+		select {
+		case <-time.After(policy.backoff(attempt)):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
@@ -7,11 +7,16 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,18 +27,36 @@ type ArgumentInfo struct {
 	Description string
 }
 
-// Information about a service.
+// Information about a service. The version is a semantic version
+// (e.g. "1.2.0") of the service contract, see ParseVersion(). Calls of
+// idempotent services may be retried even if they already reached the
+// service (see RetryPolicy). Streaming services send any number of results
+// (see RunStreamService()), interactive services hold sessions in which
+// both sides send messages (see RunSessionService()).
 type ServiceInfo struct {
 	Name        string
+	Version     string
 	ResultType  string
 	Description string
 	Arguments   []ArgumentInfo
+	Idempotent  bool `json:",omitempty"`
+	Streaming   bool `json:",omitempty"`
+	Interactive bool `json:",omitempty"`
 }
 
 // Information about service that belongs to a specific address.
+// The origin is empty for services registered at the asking registry,
+// otherwise it's the address of the peer registry the service is from.
 type ServiceInfoAddress struct {
 	Address string
 	Info    ServiceInfo
+	Origin  string
+}
+
+// Response of the registry to a service registration. An empty
+// error means that the service has been registered.
+type RegistrationResponse struct {
+	Error string
 }
 
 // Call parameter for a service.
@@ -41,27 +64,78 @@ type ServiceInfoAddress struct {
 type ServiceCall struct {
 	Name      string
 	Arguments []string
+	// ID of a call on a persistent connection (see CONNECTION_POOLING),
+	// 0 for a single call per connection.
+	ID uint64 `json:",omitempty"`
+	// Additional data of the call, e.g. credentials or trace IDs set by
+	// interceptors (see AddClientInterceptor()).
+	Metadata map[string]string `json:",omitempty"`
+	// Address of the caller, set by the service.
+	Caller string `json:"-"`
+	// Whether the results are streamed (see CallServiceStream()).
+	Stream bool `json:",omitempty"`
+	// Whether the call opens a session (see OpenSession()).
+	Session bool `json:",omitempty"`
+	// Whether the call starts a job (see StartJob()), and the service which
+	// is called when the job is finished.
+	Job      bool   `json:",omitempty"`
+	Callback string `json:",omitempty"`
+	// canceled when the job of the call is canceled
+	ctx context.Context
+}
+
+// Returns the context of the call, which is canceled when the job of the
+// call is canceled (see StartJob()). Long-running handlers should check it.
+func (servicecall *ServiceCall) Context() context.Context {
+	if servicecall.ctx == nil {
+		return context.Background()
+	}
+	return servicecall.ctx
 }
 
 // Return value of a service.
 // This structure is sent upon return of a service.
 type ServiceResult struct {
 	Result string
+	// ID of the answered call.
+	ID uint64 `json:",omitempty"`
+	// Set instead of Result if the service couldn't handle the call.
+	Error *ServiceError `json:",omitempty"`
+	// Marks the last result of a streaming call, which carries no result.
+	End bool `json:",omitempty"`
 }
 
 // Definition of the Service handler function, which will be
 // invoked when the service is being called.
 type ServiceHandler func(*ServiceCall) string
 
+// Runtime statistics of a service. They are returned as JSON result of
+// a call to HEALTH_CHECK_CALL, which is used by the registry.
+type ServiceStats struct {
+	Started time.Time
+	Calls   uint64
+	Active  int64
+	// calls waiting for a free slot (see MAX_CONCURRENT_CALLS) and calls
+	// rejected as overloaded or rate limited
+	Queued   int64
+	Rejected uint64
+}
+
 // Service information lookup request. This is used to query
 // information about a service. Valid values are "address"
 // (which returns the network address of the given service name,
 // "info" (which returns information about the given service name
-// and "list" (which returns a map (name to info) containing all
-// available services.
+// "list" (which returns a map (name to info) containing all
+// available services) and "instances" (which returns all registered
+// instances of the given service name, regardless of their version).
+// The optional version constraint (see MatchVersion()) selects which
+// registered version of a service is used for "address" and "info".
+// Service names may contain a namespace (e.g. "team-a/random"), for
+// "list" the service name is the namespace to list ("" lists all).
 type LookupInfoRequest struct {
 	Operation   string
 	ServiceName string
+	Version     string
 }
 
 // Response to a service address lookup request (holds service address).
@@ -90,16 +164,53 @@ var (
 	OPERATION_INFO = "info"
 	// Operation for LookupInfoRequest: get service list.
 	OPERATION_LIST = "list"
+	// Operation for LookupInfoRequest: get all instances of a service.
+	OPERATION_INSTANCES = "instances"
 	// Map which contains information about all available services.
-	// The mapping is from service name to service information.
-	services = make(map[string]ServiceInfoAddress)
+	// The mapping is from service name to all registered instances
+	// (possibly with different versions) of that service.
+	services = make(map[string][]ServiceInfoAddress)
+	// Guards the services map, which is shared by all registry connections.
+	servicesLock sync.Mutex
+	// Whether the registry refuses registrations which break compatibility
+	// with a registered instance of the same major version.
+	REGISTRY_CHECK_COMPATIBILITY = true
 	// Cache for registry address.
 	registryAddress *net.TCPAddr = nil
+	// Guards registryAddress, which is shared by concurrent calls.
+	registryAddressLock sync.Mutex
+	// Name of the call which every service answers with its ServiceStats
+	// instead of invoking its handler.
+	HEALTH_CHECK_CALL = "_health"
+	// Discovery mode: services register at a central registry.
+	DISCOVERY_REGISTRY = "registry"
+	// Discovery mode: services publish themselves via mDNS / DNS-SD and
+	// clients resolve them via mDNS, no registry is needed.
+	DISCOVERY_MDNS = "mdns"
+	// Discovery mode used by RunService() and all lookups (see also
+	// DISCOVERY_GOSSIP). Defaults to
+	// the environment variable HTW_DISCOVERY or "registry" if it's unset.
+	// RunService() and lookups fail with an error for unknown modes.
+	DISCOVERY_MODE = discoveryModeFromEnvironment()
 )
 
+// Returns the discovery mode from the environment variable HTW_DISCOVERY.
+func discoveryModeFromEnvironment() string {
+	mode := os.Getenv("HTW_DISCOVERY")
+	if mode == "" {
+		return DISCOVERY_REGISTRY
+	}
+	return mode
+}
+
+// Returns the error for an unknown DISCOVERY_MODE.
+func unknownDiscoveryMode() error {
+	return errors.New("error: unknown discovery mode \"" + DISCOVERY_MODE + "\" (HTW_DISCOVERY)")
+}
+
 // Returns the address of any registry which is currently active on the given interface or localhost.
 func GetRegistryAddressFromInterface(intf net.Interface, localhost bool, ch chan *net.TCPAddr) {
-	request := LookupInfoRequest{OPERATION_ADDRESS, "registry"}
+	request := LookupInfoRequest{OPERATION_ADDRESS, "registry", ""}
 	response := LookupAddressResponse{}
 	buffer := make([]byte, PACKET_SIZE)
 	var connection *net.UDPConn
@@ -139,14 +250,21 @@ func GetRegistryAddressFromInterface(intf net.Interface, localhost bool, ch chan
 	}
 	
 	if response.Address.Port != 0 {
-		ch <- &net.TCPAddr{address.IP, response.Address.Port, address.Zone}
+		ch <- &net.TCPAddr{IP: address.IP, Port: response.Address.Port, Zone: address.Zone}
 	}
 }
 
-// Returns the address of any registry which is currently active.
+// Returns the address of any registry which is currently active. If
+// REGISTRY_ADDRESS is set, that registry is used instead.
 func GetRegistryAddress() (*net.TCPAddr, error) {
-	if registryAddress != nil {
-		return registryAddress, nil
+	registryAddressLock.Lock()
+	cached := registryAddress
+	registryAddressLock.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+	if REGISTRY_ADDRESS != "" {
+		return net.ResolveTCPAddr(TCP_PROTOCOL, REGISTRY_ADDRESS)
 	}
 
 	ch := make(chan *net.TCPAddr, 1)
@@ -162,13 +280,21 @@ func GetRegistryAddress() (*net.TCPAddr, error) {
 	
 	select {
     case address := <-ch:
+		registryAddressLock.Lock()
 		registryAddress = address
+		registryAddressLock.Unlock()
         return address, nil
     case <-time.After(6 * time.Second):
         return nil, errors.New("error: no registry found!")
     }
-	
-	return nil, nil
+}
+
+// Forgets the cached registry address, so that the next call of
+// GetRegistryAddress() searches for an active registry again.
+func resetRegistryAddress() {
+	registryAddressLock.Lock()
+	registryAddress = nil
+	registryAddressLock.Unlock()
 }
 
 // Get service information for the given operation as JOSN.
@@ -176,10 +302,32 @@ func GetRegistryAddress() (*net.TCPAddr, error) {
 // * "address"
 // * "info"
 // * "list"
+// * "instances"
 func GetServiceData(operation, name string) ([]byte, error) {
-	request := LookupInfoRequest{operation, name}
+	return GetServiceDataVersion(operation, name, "")
+}
+
+// Same as GetServiceData(), but only considers service versions
+// which satisfy the given version constraint (e.g. "^1.2").
+func GetServiceDataVersion(operation, name, constraint string) ([]byte, error) {
+	request := LookupInfoRequest{operation, name, constraint}
 	buffer := make([]byte, PACKET_SIZE)
 
+	err := ValidateConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+
+	switch DISCOVERY_MODE {
+	case DISCOVERY_MDNS:
+		return lookupMDNS(&request)
+	case DISCOVERY_GOSSIP:
+		return lookupGossip(&request)
+	case DISCOVERY_REGISTRY:
+	default:
+		return nil, unknownDiscoveryMode()
+	}
+
 	address, err := GetRegistryAddress()
 	if err != nil {
 		return nil, err
@@ -187,7 +335,7 @@ func GetServiceData(operation, name string) ([]byte, error) {
 
 	connection, err := net.DialTCP(TCP_PROTOCOL, nil, address)
 	if err != nil {
-		registryAddress = nil
+		resetRegistryAddress()
 		address, err = GetRegistryAddress()
 		if err != nil {
 			return nil, err
@@ -213,14 +361,29 @@ func GetServiceData(operation, name string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = lookupResponseError(buffer[:length])
+	if err != nil {
+		return nil, err
+	}
 
 	return buffer[:length], nil
 }
 
 // Returns the address for the given service name.
 func GetServiceAddress(name string) (*net.TCPAddr, error) {
+	return GetServiceAddressVersion(name, "")
+}
+
+// Returns the address of the highest version of the given service
+// which satisfies the version constraint (e.g. "^1.2").
+func GetServiceAddressVersion(name, constraint string) (*net.TCPAddr, error) {
+	if cached, ok := lookupCache.get(OPERATION_ADDRESS, name, constraint); ok {
+		address := cached.(net.TCPAddr)
+		return &address, nil
+	}
+
 	response := LookupAddressResponse{}
-	buffer, err := GetServiceData(OPERATION_ADDRESS, name)
+	buffer, err := GetServiceDataVersion(OPERATION_ADDRESS, name, constraint)
 	if err != nil {
 		return nil, err
 	}
@@ -229,14 +392,29 @@ func GetServiceAddress(name string) (*net.TCPAddr, error) {
 	if err != nil {
 		return nil, err
 	}
+	if response.Address.Port == 0 {
+		return nil, errors.New("error: service \"" + name + "\" not found!")
+	}
+	lookupCache.put(OPERATION_ADDRESS, name, constraint, response.Address)
 
 	return &response.Address, nil
 }
 
 // Returns ServiceInfoAddress for the given service name.
 func GetServiceInfo(name string) (*ServiceInfoAddress, error) {
+	return GetServiceInfoVersion(name, "")
+}
+
+// Returns ServiceInfoAddress for the highest version of the given
+// service which satisfies the version constraint (e.g. "^1.2").
+func GetServiceInfoVersion(name, constraint string) (*ServiceInfoAddress, error) {
+	if cached, ok := lookupCache.get(OPERATION_INFO, name, constraint); ok {
+		response := cached.(ServiceInfoAddress)
+		return &response, nil
+	}
+
 	response := ServiceInfoAddress{}
-	buffer, err := GetServiceData(OPERATION_INFO, name)
+	buffer, err := GetServiceDataVersion(OPERATION_INFO, name, constraint)
 	if err != nil {
 		return nil, err
 	}
@@ -245,14 +423,40 @@ func GetServiceInfo(name string) (*ServiceInfoAddress, error) {
 	if err != nil {
 		return nil, err
 	}
+	if response.Address != "" {
+		lookupCache.put(OPERATION_INFO, name, constraint, response)
+	}
 
 	return &response, nil
 }
 
+// Returns all registered instances (of all versions) of the given service.
+func GetServiceInstances(name string) ([]ServiceInfoAddress, error) {
+	response := []ServiceInfoAddress{}
+	buffer, err := GetServiceData(OPERATION_INSTANCES, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(buffer, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
 // Returns a map (map[string]ServiceInfoAddress) containing all services.
+// If several versions of a service are registered, the highest one is listed.
 func GetServiceList() (*map[string]ServiceInfoAddress, error) {
+	return GetServiceListNamespace("")
+}
+
+// Returns a map (map[string]ServiceInfoAddress) containing all services of
+// the given namespace (e.g. "team-a"). An empty namespace lists all services.
+func GetServiceListNamespace(namespace string) (*map[string]ServiceInfoAddress, error) {
 	response := make(map[string]ServiceInfoAddress)
-	buffer, err := GetServiceData(OPERATION_LIST, "")
+	buffer, err := GetServiceData(OPERATION_LIST, namespace)
 	if err != nil {
 		return nil, err
 	}
@@ -265,25 +469,125 @@ func GetServiceList() (*map[string]ServiceInfoAddress, error) {
 	return &response, nil
 }
 
-// Handles connections to a service and calls the handler specified in RunService().
-func handleServiceConnection(connection *net.TCPConn, handler ServiceHandler) error {
-	servicecall := ServiceCall{}
-	buffer := make([]byte, PACKET_SIZE)
+// State of a service started by RunService(), RunStreamService() or
+// RunSessionService(), shared by all connections.
+type serviceRuntime struct {
+	info    *ServiceInfo
+	handler ServiceHandler
+	stream  StreamHandler
+	session SessionHandler
+	stats   ServiceStats
+	limiter *callLimiter
+	jobs    jobTable
+	// number of open sessions
+	sessions int64
+}
+
+// Invokes the handler of the service and updates the statistics. Calls
+// to HEALTH_CHECK_CALL are answered with the statistics instead, calls in
+// job mode with the ID of the job (see StartJob()). Returns
+// an error instead of a result if the call exceeds a rate limit, the
+// service is overloaded or the handler panicked.
+func (runtime *serviceRuntime) call(servicecall *ServiceCall) ServiceResult {
+	stats := &runtime.stats
+	if servicecall.Name == HEALTH_CHECK_CALL {
+		current := ServiceStats{
+			Started:  stats.Started,
+			Calls:    atomic.LoadUint64(&stats.Calls),
+			Active:   atomic.LoadInt64(&stats.Active),
+			Queued:   runtime.limiter.queuedCalls(),
+			Rejected: atomic.LoadUint64(&stats.Rejected),
+		}
+		result, _ := json.Marshal(current)
+		return ServiceResult{Result: string(result)}
+	}
+
+	if runtime.stream != nil {
+		return ServiceResult{Error: &ServiceError{SERVICE_STREAMING, "use CallServiceStream()"}}
+	}
+	if runtime.session != nil {
+		return ServiceResult{Error: &ServiceError{SERVICE_INTERACTIVE, "use OpenSession()"}}
+	}
+	if servicecall.Name == JOB_STATUS_CALL || servicecall.Name == JOB_CANCEL_CALL {
+		return runtime.jobCall(servicecall)
+	}
+	if servicecall.Job {
+		return runtime.startJob(servicecall)
+	}
+
+	release, err := runtime.admit(servicecall)
+	if err != nil {
+		return ServiceResult{Error: err}
+	}
+	defer release()
+	return runtime.invoke(servicecall)
+}
+
+// Checks the rate limits and waits for a free slot for a call, and updates
+// the statistics. Returns the error if the call is rejected, otherwise a
+// function which must be called when the call is done.
+func (runtime *serviceRuntime) admit(servicecall *ServiceCall) (func(), *ServiceError) {
+	stats := &runtime.stats
+	err := checkRateLimits(runtime.info, servicecall)
+	if err == nil {
+		err = runtime.limiter.acquire()
+	}
+	if err != nil {
+		atomic.AddUint64(&stats.Rejected, 1)
+		return nil, err
+	}
+
+	atomic.AddUint64(&stats.Calls, 1)
+	atomic.AddInt64(&stats.Active, 1)
+	return func() {
+		atomic.AddInt64(&stats.Active, -1)
+		runtime.limiter.release()
+	}, nil
+}
 
+// Handles connections to a service and calls the handler specified in RunService().
+// Requests in JSON-RPC 2.0 format are answered in that format (if enabled),
+// requests which aren't valid JSON with a JSON-RPC parse error then.
+// A call without ID is answered and the connection is closed afterwards. A
+// call with ID keeps the connection open for further calls (see serveMultiplexed()),
+// a streaming call for its results (see serveStream()) and a session call for
+// the session (see serveSession()).
+func handleServiceConnection(connection *net.TCPConn, runtime *serviceRuntime) error {
 	defer connection.Close()
+	decoder := json.NewDecoder(connection)
 
-	length, err := connection.Read(buffer)
+	message := json.RawMessage{}
+	err := decoder.Decode(&message)
+	if _, invalid := err.(*json.SyntaxError); (invalid || err == io.ErrUnexpectedEOF) && JSONRPC_ENABLED {
+		connection.Write(jsonrpcParseError())
+	}
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(buffer[:length], &servicecall)
+	if JSONRPC_ENABLED && isJSONRPC(message) {
+		response := handleJSONRPC(message, connection.RemoteAddr().String(), runtime)
+		if response != nil {
+			_, err = connection.Write(response)
+		}
+		return err
+	}
+	servicecall := ServiceCall{}
+	err = json.Unmarshal(message, &servicecall)
 	if err != nil {
 		return err
 	}
+	servicecall.Caller = connection.RemoteAddr().String()
+	if servicecall.Session {
+		return serveSession(connection, decoder, runtime, servicecall)
+	}
+	if servicecall.Stream {
+		return serveStream(connection, decoder, runtime, servicecall)
+	}
+	if servicecall.ID != 0 {
+		return serveMultiplexed(connection, decoder, runtime, servicecall)
+	}
 
-	ret := handler(&servicecall)
-
-	bytes, err := json.Marshal(ServiceResult{ret})
+	bytes, err := json.Marshal(runtime.call(&servicecall))
 	if err != nil {
 		return err
 	}
@@ -298,29 +602,62 @@ func handleServiceConnection(connection *net.TCPConn, handler ServiceHandler) er
 // Registers and starts a service. Any requests to the service are given to
 // the user defined handler. Note that this function blocks forever.
 func RunService(serviceinfo *ServiceInfo, handler ServiceHandler) error {
-	address, err := GetRegistryAddress()
+	return runService(serviceinfo, &serviceRuntime{info: serviceinfo, handler: interceptHandler(serviceinfo, handler)})
+}
+
+// Registers and starts a service with the given handler (runtime).
+func runService(serviceinfo *ServiceInfo, runtime *serviceRuntime) error {
+	err := ValidateContract(serviceinfo)
 	if err != nil {
 		return err
 	}
 
-	connection, err := net.DialTCP(TCP_PROTOCOL, nil, address)
+	listener, err := net.ListenTCP(TCP_PROTOCOL, TCP_ANY_ADDR)
 	if err != nil {
 		return err
 	}
-	defer connection.Close()
+	defer listener.Close()
 
-	listener, err := net.ListenTCP(TCP_PROTOCOL, TCP_ANY_ADDR)
+	address, err := net.ResolveTCPAddr(TCP_PROTOCOL, listener.Addr().String())
+	if err != nil {
+		return err
+	}
+
+	switch DISCOVERY_MODE {
+	case DISCOVERY_MDNS:
+		err = publishServiceMDNS(serviceinfo, address.Port)
+	case DISCOVERY_GOSSIP:
+		err = publishServiceGossip(serviceinfo, address.Port)
+	case DISCOVERY_REGISTRY:
+		err = registerService(serviceinfo, address.Port)
+	default:
+		err = unknownDiscoveryMode()
+	}
+	if err != nil {
+		return err
+	}
+
+	runtime.limiter = newCallLimiter()
+	runtime.stats.Started = time.Now()
+	return acceptConnections(listener, func(connection *net.TCPConn) {
+		handleServiceConnection(connection, runtime)
+	})
+}
+
+// Registers the service listening on the given port at the registry.
+func registerService(serviceinfo *ServiceInfo, port int) error {
+	address, err := GetRegistryAddress()
 	if err != nil {
 		return err
 	}
-	defer listener.Close()
 
-	address, err = net.ResolveTCPAddr(TCP_PROTOCOL, listener.Addr().String())
+	connection, err := net.DialTCP(TCP_PROTOCOL, nil, address)
 	if err != nil {
 		return err
 	}
+	defer connection.Close()
 
-	bytes, err := json.Marshal(ServiceInfoAddress{strconv.Itoa(address.Port), *serviceinfo})
+	bytes, err := json.Marshal(ServiceInfoAddress{Address: strconv.Itoa(port), Info: *serviceinfo})
 	if err != nil {
 		return err
 	}
@@ -330,11 +667,13 @@ func RunService(serviceinfo *ServiceInfo, handler ServiceHandler) error {
 		return err
 	}
 
-	for {
-		connection, err := listener.AcceptTCP()
-		if err == nil {
-			go handleServiceConnection(connection, handler)
-		}
+	// registries without compatibility checks just close the connection
+	response := RegistrationResponse{}
+	buffer := make([]byte, PACKET_SIZE)
+	connection.SetReadDeadline(time.Now().Add(time.Second * 4))
+	length, err := connection.Read(buffer)
+	if err == nil && json.Unmarshal(buffer[:length], &response) == nil && response.Error != "" {
+		return errors.New(response.Error)
 	}
 
 	return nil
@@ -412,35 +751,158 @@ func handleRegistryConnection(connection *net.TCPConn) error {
 	if err != nil {
 		return err
 	}
-
-	if lookuprequest.Operation == OPERATION_ADDRESS {
-		fmt.Println("service address:", lookuprequest.ServiceName)
-		address, _ := net.ResolveTCPAddr(TCP_PROTOCOL, services[lookuprequest.ServiceName].Address)
-		bytes, _ := json.Marshal(LookupAddressResponse{*address})
+	if lookuprequest.Operation == OPERATION_WATCH {
+		fmt.Println("registry watch:", connection.RemoteAddr())
+		return serveWatch(connection)
+	}
+	if bytes, limited := checkLookupRateLimit(lookuprequest.Operation, connection.RemoteAddr()); limited {
+		fmt.Println("lookup rate limited:", connection.RemoteAddr())
 		connection.Write(bytes)
-	} else if lookuprequest.Operation == OPERATION_INFO {
-		fmt.Println("service info:", lookuprequest.ServiceName)
-		bytes, _ := json.Marshal(services[lookuprequest.ServiceName])
+		return nil
+	}
+
+	servicesLock.Lock()
+	defer servicesLock.Unlock()
+
+	localIP := connection.LocalAddr().(*net.TCPAddr).IP
+	if lookuprequest.Operation != OPERATION_LIST {
+		lookuprequest.ServiceName = CanonicalServiceName(lookuprequest.ServiceName)
+	}
+
+	if bytes, ok := lookupResponse(&lookuprequest, knownServices(localIP)); ok {
+		fmt.Println("service "+lookuprequest.Operation+":", lookuprequest.ServiceName, lookuprequest.Version)
 		connection.Write(bytes)
-	} else if lookuprequest.Operation == OPERATION_LIST {
-		fmt.Println("service list")
-		bytes, _ := json.Marshal(services)
+	} else if lookuprequest.Operation == OPERATION_CATALOG {
+		fmt.Println("service catalog")
+		bytes, _ := json.Marshal(localCatalog(localIP))
 		connection.Write(bytes)
 	} else if serviceinfoaddress.Address != "" {
 		address, _ := net.ResolveTCPAddr(TCP_PROTOCOL, connection.RemoteAddr().String())
 		address.Port, _ = strconv.Atoi(serviceinfoaddress.Address)
 		serviceinfoaddress.Address = address.String()
-		fmt.Println("service registered:", serviceinfoaddress.Info.Name)
-		services[serviceinfoaddress.Info.Name] = serviceinfoaddress
+		serviceinfoaddress.Info.Name = CanonicalServiceName(serviceinfoaddress.Info.Name)
+		response := RegistrationResponse{}
+		err = ValidateServiceName(serviceinfoaddress.Info.Name)
+		if err == nil {
+			err = checkNamespaceQuota(serviceinfoaddress)
+		}
+		if err == nil && REGISTRY_CHECK_COMPATIBILITY {
+			err = checkCompatibility(services[serviceinfoaddress.Info.Name], serviceinfoaddress)
+		}
+		if err != nil {
+			fmt.Println("service refused:", err)
+			response.Error = err.Error()
+		} else {
+			fmt.Println("service registered:", serviceinfoaddress.Info.Name, serviceinfoaddress.Info.Version)
+			registerInstance(serviceinfoaddress)
+		}
+		bytes, _ := json.Marshal(response)
+		connection.Write(bytes)
 	}
 
 	return nil
 }
 
-// Starts a registry server on "0.0.0.0" alias any address. Note that this
-// function blocks forever.
+// Answers a lookup request ("address", "info", "list" or "instances")
+// from the given services (mapping from canonical service name to
+// instances) and returns the JSON response, which carries an error if the
+// version constraint is invalid. If the operation is not a lookup
+// operation, false is returned.
+func lookupResponse(lookuprequest *LookupInfoRequest, known map[string][]ServiceInfoAddress) ([]byte, bool) {
+	var response interface{}
+
+	switch lookuprequest.Operation {
+	case OPERATION_ADDRESS, OPERATION_INFO:
+		err := ValidateConstraint(lookuprequest.Version)
+		if err != nil {
+			bytes, _ := json.Marshal(lookupError{&ServiceError{SERVICE_INVALID_VERSION, err.Error()}})
+			return bytes, true
+		}
+	}
+
+	switch lookuprequest.Operation {
+	case OPERATION_ADDRESS:
+		address := LookupAddressResponse{}
+		instance, found, _ := selectInstance(known[lookuprequest.ServiceName], lookuprequest.Version)
+		if found {
+			resolved, err := net.ResolveTCPAddr(TCP_PROTOCOL, instance.Address)
+			if err == nil {
+				address.Address = *resolved
+			}
+		}
+		response = address
+	case OPERATION_INFO:
+		response, _, _ = selectInstance(known[lookuprequest.ServiceName], lookuprequest.Version)
+	case OPERATION_LIST:
+		list := make(map[string]ServiceInfoAddress)
+		for name, instances := range known {
+			if inNamespace(name, lookuprequest.ServiceName) {
+				list[name], _, _ = selectInstance(instances, "")
+			}
+		}
+		response = list
+	case OPERATION_INSTANCES:
+		instances := known[lookuprequest.ServiceName]
+		if instances == nil {
+			instances = []ServiceInfoAddress{}
+		}
+		response = instances
+	default:
+		return nil, false
+	}
+
+	bytes, _ := json.Marshal(response)
+	return bytes, true
+}
+
+// Adds a service instance to the services map. Instances which the new
+// one replaces (see replacesInstance()) are removed, all other instances
+// (e.g. other versions of the service) are kept side by side. The health
+// of the instance is reset, since it may be another process. The caller
+// must hold servicesLock.
+func registerInstance(serviceinfoaddress ServiceInfoAddress) {
+	name := serviceinfoaddress.Info.Name
+	kept := []ServiceInfoAddress{}
+	for _, instance := range services[name] {
+		if !replacesInstance(instance, serviceinfoaddress) {
+			kept = append(kept, instance)
+		} else if instance.Address != serviceinfoaddress.Address {
+			delete(instanceHealth, instance.Address)
+			delete(healthReset, instance.Address)
+			delete(drainedInstances, instance.Address)
+			publishEvent(RegistryEvent{EVENT_DEREGISTERED, name, instance.Address})
+		}
+	}
+
+	publishEvent(RegistryEvent{EVENT_REGISTERED, name, serviceinfoaddress.Address})
+	delete(instanceHealth, serviceinfoaddress.Address)
+	healthReset[serviceinfoaddress.Address] = time.Now()
+	services[name] = append(kept, serviceinfoaddress)
+}
+
+// Returns true if the registration of the candidate replaces the
+// registered instance of the same service: it has the same address, or
+// the same version on the same host, since the service was most likely
+// restarted on another port.
+func replacesInstance(instance, candidate ServiceInfoAddress) bool {
+	if instance.Address == candidate.Address {
+		return true
+	}
+	host, _, err := net.SplitHostPort(instance.Address)
+	candidateHost, _, candidateErr := net.SplitHostPort(candidate.Address)
+	return err == nil && candidateErr == nil && host == candidateHost &&
+		instance.Info.Version == candidate.Info.Version
+}
+
+// Starts a registry server on "0.0.0.0" alias any address and the port
+// REGISTRY_PORT. If REGISTRY_PEERS are given, the registry federates with
+// them, if REGISTRY_MDNS is set, all services are published via mDNS.
+// If REGISTRY_HEALTH_INTERVAL is set, all instances are checked
+// periodically; if REGISTRY_HTTP_PORT is set, the HTTP admin API is
+// started as well.
+// Note that this function blocks forever.
 func RunRegistryServer() error {
-	listener, err := net.ListenTCP(TCP_PROTOCOL, TCP_ANY_ADDR)
+	listener, err := net.ListenTCP(TCP_PROTOCOL, &net.TCPAddr{IP: TCP_ANY_ADDR.IP, Port: REGISTRY_PORT})
 	if err != nil {
 		return err
 	}
@@ -452,6 +914,24 @@ func RunRegistryServer() error {
 	defer listener.Close()
 
 	go registryLookupService(address)
+	if len(REGISTRY_PEERS) > 0 {
+		go federationService()
+	}
+	if REGISTRY_MDNS {
+		err = registryMDNSResponder()
+		if err != nil {
+			return err
+		}
+	}
+	if REGISTRY_HEALTH_INTERVAL > 0 {
+		go registryHealthService()
+	}
+	if REGISTRY_HTTP_PORT != 0 {
+		err = startRegistryHTTPServer()
+		if err != nil {
+			return err
+		}
+	}
 
 	for {
 		connection, err := listener.AcceptTCP()
@@ -463,37 +943,100 @@ func RunRegistryServer() error {
 
 // Invokes the service specified by name with the given arguments.
 func CallService(name string, args ...string) (string, error) {
-	servicecall := ServiceCall{name, args}
+	return CallServiceVersion(name, "", args...)
+}
+
+// Invokes the highest version of the service specified by name which
+// satisfies the version constraint (e.g. "^1.2") with the given arguments.
+func CallServiceVersion(name, constraint string, args ...string) (string, error) {
+	return CallServiceContext(context.Background(), name, constraint, args...)
+}
+
+// Invokes the highest version of the service specified by name which
+// satisfies the version constraint with the given arguments. The call is
+// aborted when the context is canceled or its deadline is exceeded. Failed
+// calls are retried according to the retry policy of the service (see
+// RETRY_POLICIES), possibly at another instance.
+func CallServiceContext(ctx context.Context, name, constraint string, args ...string) (string, error) {
+	invoker := func(ctx context.Context, servicecall *ServiceCall) (string, error) {
+		return callServiceRetry(ctx, servicecall, constraint)
+	}
+	return interceptCall(ctx, &ServiceCall{Name: name, Arguments: args}, invoker)
+}
+
+// Invokes the service with the given name at the given address, without
+// looking up the address at the registry.
+func CallServiceAddress(address *net.TCPAddr, name string, args ...string) (string, error) {
+	return CallServiceAddressContext(context.Background(), address, name, args...)
+}
+
+// Invokes the service with the given name at the given address. The call
+// is aborted when the context is canceled or its deadline is exceeded.
+func CallServiceAddressContext(ctx context.Context, address *net.TCPAddr, name string, args ...string) (string, error) {
+	invoker := func(ctx context.Context, servicecall *ServiceCall) (string, error) {
+		return callAddress(ctx, address, *servicecall)
+	}
+	return interceptCall(ctx, &ServiceCall{Name: name, Arguments: args}, invoker)
+}
+
+// Sends a call to the service at the given address and returns its result.
+func callAddress(ctx context.Context, address *net.TCPAddr, servicecall ServiceCall) (string, error) {
 	serviceresult := ServiceResult{}
-	buffer := make([]byte, PACKET_SIZE)
 
-	address, err := GetServiceAddress(name)
-	if err != nil {
-		return "", err
+	if CONNECTION_POOLING && !pool.isLegacy(address.String()) {
+		serviceresult, err := pool.call(ctx, address.String(), servicecall)
+		if err == nil && serviceresult.Error != nil {
+			return "", serviceresult.Error
+		}
+		if err != errNotPooled {
+			return serviceresult.Result, err
+		}
+		// the call wasn't processed, send it on a connection of its own
 	}
-	connection, err := net.DialTCP(TCP_PROTOCOL, nil, address)
+
+	dialer := net.Dialer{}
+	connection, err := dialer.DialContext(ctx, TCP_PROTOCOL, address.String())
 	if err != nil {
 		return "", err
 	}
 	defer connection.Close()
 
+	// interrupt pending reads and writes when the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			connection.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
 	bytes, err := json.Marshal(servicecall)
 	if err != nil {
 		return "", err
 	}
 	_, err = connection.Write(bytes)
 	if err != nil {
-		return "", err
+		return "", contextError(ctx, err)
 	}
 
-	length, err := connection.Read(buffer)
+	// results may exceed PACKET_SIZE
+	err = json.NewDecoder(connection).Decode(&serviceresult)
 	if err != nil {
-		return "", err
+		return "", contextError(ctx, err)
 	}
-	err = json.Unmarshal(buffer[:length], &serviceresult)
-	if err != nil {
-		return "", err
+	if serviceresult.Error != nil {
+		return "", serviceresult.Error
 	}
 
 	return serviceresult.Result, nil
 }
+
+// Returns the error of the context if it's done, otherwise err.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Handler of an interactive service (see RunSessionService()), which
+// exchanges messages with the client over the session. The session ends
+// when the handler returns; a returned error is sent to the client, unless
+// the handler closed the session for sending. The context is canceled when
+// the client closes the session.
+type SessionHandler func(ctx context.Context, servicecall *ServiceCall, session *Session) error
+
+// Message of a session. Messages are sent as JSON values one after another
+// on the connection of the session.
+type SessionMessage struct {
+	Message string `json:",omitempty"`
+	// The sender won't send further messages (half-close).
+	End bool `json:",omitempty"`
+	// Error which ended the session, sent by the service with End.
+	Error *ServiceError `json:",omitempty"`
+}
+
+var (
+	// Maximum time Session.Receive() waits for a message (0 = no limit).
+	SESSION_IDLE_TIMEOUT = 5 * time.Minute
+	// Maximum number of open sessions per service (0 = no limit). Further
+	// sessions are rejected with SERVICE_OVERLOADED. Sessions don't count
+	// against MAX_CONCURRENT_CALLS, since they last long.
+	MAX_SESSIONS = 64
+	// Maximum time the service waits for the client to close its side
+	// after the end of a session.
+	SESSION_CLOSE_TIMEOUT = 5 * time.Second
+	// Error of Session.Receive() if no message arrived within
+	// SESSION_IDLE_TIMEOUT. The session stays open.
+	ErrSessionTimeout = errors.New("error: session timeout")
+	// Error of Session.Send() after Session.CloseSend().
+	ErrSessionClosed = errors.New("error: session closed for sending")
+)
+
+const (
+	// The service doesn't hold sessions (see OpenSession()).
+	SERVICE_UNSUPPORTED = "unsupported"
+	// The service holds sessions, so it has to be called with
+	// OpenSession().
+	SERVICE_INTERACTIVE = "interactive"
+)
+
+// A session between a client and an interactive service over one
+// connection, in which both sides send any number of messages. Send() and
+// Receive() may be used by different goroutines at the same time.
+type Session struct {
+	connection net.Conn
+	encoder    *json.Encoder
+	ctx        context.Context
+	cancel     context.CancelFunc
+	// guards the following field and writes to the connection
+	sendLock   sync.Mutex
+	sendClosed bool
+	// received messages, closed when the peer ended the session; err is
+	// set before
+	messages chan string
+	err      error
+}
+
+// Returns a session on the connection and starts receiving messages.
+func newSession(ctx context.Context, connection net.Conn, decoder *json.Decoder) *Session {
+	ctx, cancel := context.WithCancel(ctx)
+	session := &Session{
+		connection: connection,
+		encoder:    json.NewEncoder(connection),
+		ctx:        ctx,
+		cancel:     cancel,
+		messages:   make(chan string, STREAM_BUFFER),
+	}
+	go session.receive(decoder)
+
+	// interrupt pending reads and writes when the session is closed
+	go func() {
+		<-ctx.Done()
+		connection.SetDeadline(time.Now())
+	}()
+	return session
+}
+
+// Receives messages until the peer ends the session. The receiver waits
+// while STREAM_BUFFER messages haven't been read yet.
+func (session *Session) receive(decoder *json.Decoder) {
+	defer close(session.messages)
+	for {
+		message := SessionMessage{}
+		err := decoder.Decode(&message)
+		switch {
+		case err == io.EOF:
+			session.err = io.ErrUnexpectedEOF
+			session.cancel()
+			return
+		case err != nil:
+			session.err = contextError(session.ctx, err)
+			session.cancel()
+			return
+		case message.Error != nil:
+			session.err = message.Error
+			return
+		case message.End:
+			session.err = io.EOF
+			return
+		}
+
+		select {
+		case session.messages <- message.Message:
+		case <-session.ctx.Done():
+			session.err = session.ctx.Err()
+			return
+		}
+	}
+}
+
+// Sends a message to the peer.
+func (session *Session) Send(message string) error {
+	return session.send(SessionMessage{Message: message})
+}
+
+// Sends a message and closes the session for sending if End is set.
+func (session *Session) send(message SessionMessage) error {
+	session.sendLock.Lock()
+	defer session.sendLock.Unlock()
+
+	if session.sendClosed {
+		return ErrSessionClosed
+	}
+	session.sendClosed = message.End
+	if session.ctx.Err() != nil {
+		return session.ctx.Err()
+	}
+	session.connection.SetWriteDeadline(time.Now().Add(STREAM_SEND_TIMEOUT))
+	return contextError(session.ctx, session.encoder.Encode(message))
+}
+
+// Tells the peer that no further messages will be sent (half-close). The
+// peer can still send messages.
+func (session *Session) CloseSend() error {
+	return session.send(SessionMessage{End: true})
+}
+
+// Returns the next message of the peer. Returns io.EOF after the peer
+// closed the session for sending, the error of the service if it ended the
+// session with an error, or ErrSessionTimeout if no message arrived within
+// SESSION_IDLE_TIMEOUT.
+func (session *Session) Receive() (string, error) {
+	var timeout <-chan time.Time
+	if SESSION_IDLE_TIMEOUT > 0 {
+		timer := time.NewTimer(SESSION_IDLE_TIMEOUT)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case message, ok := <-session.messages:
+		if !ok {
+			return "", session.err
+		}
+		return message, nil
+	case <-timeout:
+		return "", ErrSessionTimeout
+	}
+}
+
+// Closes the session. On the client, this cancels the context of the
+// handler of the service.
+func (session *Session) Close() error {
+	session.cancel()
+	return session.connection.Close()
+}
+
+// Registers and starts an interactive service, whose calls are handled by
+// the given handler as sessions (see OpenSession()). Sets
+// serviceinfo.Interactive. Note that this function blocks forever.
+func RunSessionService(serviceinfo *ServiceInfo, handler SessionHandler) error {
+	serviceinfo.Interactive = true
+	return runService(serviceinfo, &serviceRuntime{info: serviceinfo, session: handler})
+}
+
+// Serves a session call: invokes the handler with the session and, unless
+// the handler closed the session for sending, sends End with the error of
+// the handler, if any. Closes the connection afterwards, once the client
+// closed its side or SESSION_CLOSE_TIMEOUT passed.
+func serveSession(connection *net.TCPConn, decoder *json.Decoder, runtime *serviceRuntime, servicecall ServiceCall) error {
+	session := newSession(context.Background(), connection, decoder)
+	defer session.Close()
+
+	var err *ServiceError
+	if runtime.session == nil {
+		err = &ServiceError{SERVICE_UNSUPPORTED, "service " + runtime.info.Name + " doesn't hold sessions"}
+	} else if release, rejected := runtime.admitSession(&servicecall); rejected != nil {
+		err = rejected
+	} else {
+		err = runtime.invokeSession(session.ctx, &servicecall, session)
+		release()
+	}
+
+	result := session.send(SessionMessage{End: true, Error: err})
+
+	// messages the handler didn't read would make the connection reset on
+	// close, so the client might lose the end of the session; they are
+	// discarded until the client closes its side
+	connection.CloseWrite()
+	connection.SetReadDeadline(time.Now().Add(SESSION_CLOSE_TIMEOUT))
+	for range session.messages {
+	}
+	return result
+}
+
+// Checks the rate limits and MAX_SESSIONS for a session and updates the
+// statistics. Returns the error if the session is rejected, otherwise a
+// function which must be called when the session is done.
+func (runtime *serviceRuntime) admitSession(servicecall *ServiceCall) (func(), *ServiceError) {
+	stats := &runtime.stats
+	err := checkRateLimits(runtime.info, servicecall)
+	if err == nil {
+		sessions := atomic.AddInt64(&runtime.sessions, 1)
+		if MAX_SESSIONS > 0 && sessions > int64(MAX_SESSIONS) {
+			atomic.AddInt64(&runtime.sessions, -1)
+			err = &ServiceError{SERVICE_OVERLOADED, strconv.Itoa(MAX_SESSIONS) + " open sessions"}
+		}
+	}
+	if err != nil {
+		atomic.AddUint64(&stats.Rejected, 1)
+		return nil, err
+	}
+
+	atomic.AddUint64(&stats.Calls, 1)
+	atomic.AddInt64(&stats.Active, 1)
+	return func() {
+		atomic.AddInt64(&stats.Active, -1)
+		atomic.AddInt64(&runtime.sessions, -1)
+	}, nil
+}
+
+// Opens a session with the highest version of the interactive service
+// specified by name which satisfies the version constraint. The arguments
+// are passed to the handler like those of a call. The session must be
+// closed with Close(); it's also closed when the context is done. Sessions
+// aren't retried and not passed to client interceptors.
+func OpenSession(ctx context.Context, name, constraint string, args ...string) (*Session, error) {
+	instance, err := GetServiceInfoVersion(name, constraint)
+	if err == nil && instance.Address == "" {
+		err = errors.New("error: service \"" + name + "\" not found!")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{}
+	connection, err := dialer.DialContext(ctx, TCP_PROTOCOL, instance.Address)
+	if err != nil {
+		InvalidateLookupCache(name)
+		return nil, err
+	}
+	bytes, err := json.Marshal(ServiceCall{Name: name, Arguments: args, Session: true})
+	if err == nil {
+		_, err = connection.Write(bytes)
+	}
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+	return newSession(ctx, connection, json.NewDecoder(connection)), nil
+}
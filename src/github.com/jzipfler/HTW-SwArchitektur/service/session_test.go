@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdmitSession(t *testing.T) {
+	defer func(sessions, calls int) { MAX_SESSIONS, MAX_CONCURRENT_CALLS = sessions, calls }(MAX_SESSIONS, MAX_CONCURRENT_CALLS)
+	MAX_SESSIONS, MAX_CONCURRENT_CALLS = 2, 1
+	runtime := &serviceRuntime{info: &ServiceInfo{Name: "calculator"}, limiter: newCallLimiter()}
+
+	first, err := runtime.admitSession(&ServiceCall{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := runtime.admitSession(&ServiceCall{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.admitSession(&ServiceCall{}); err == nil || err.Code != SERVICE_OVERLOADED {
+		t.Errorf("admitSession() = %v, want %s", err, SERVICE_OVERLOADED)
+	}
+
+	// open sessions don't occupy call slots
+	release, err := runtime.admit(&ServiceCall{})
+	if err != nil {
+		t.Errorf("admit() = %v with open sessions", err)
+	} else {
+		release()
+	}
+
+	first()
+	if _, err := runtime.admitSession(&ServiceCall{}); err != nil {
+		t.Errorf("admitSession() = %v after a session ended", err)
+	}
+	second()
+}
+
+// Starts an interactive service with the handler on a local port and
+// returns a function which opens sessions with it.
+func startSessionService(t *testing.T, handler SessionHandler) func() *Session {
+	listener, err := net.ListenTCP(TCP_PROTOCOL, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime := &serviceRuntime{info: &ServiceInfo{Name: "calculator"}, session: handler}
+	go func() {
+		for {
+			connection, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			go handleServiceConnection(connection, runtime)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+
+	return func() *Session {
+		connection, err := net.Dial(TCP_PROTOCOL, listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		connection.SetDeadline(time.Now().Add(5 * time.Second))
+		bytes, _ := json.Marshal(ServiceCall{Name: "calculator", Session: true})
+		connection.Write(bytes)
+		session := newSession(context.Background(), connection, json.NewDecoder(connection))
+		t.Cleanup(func() { session.Close() })
+		return session
+	}
+}
+
+// Receives all messages of the session until it ends. The session stays
+// open after a timeout.
+func receiveAll(session *Session) ([]string, error) {
+	messages := []string{}
+	for {
+		message, err := session.Receive()
+		if err == ErrSessionTimeout {
+			continue
+		}
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, message)
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	defer func(timeout time.Duration) { SESSION_IDLE_TIMEOUT = timeout }(SESSION_IDLE_TIMEOUT)
+	SESSION_IDLE_TIMEOUT = 200 * time.Millisecond
+
+	open := startSessionService(t, func(ctx context.Context, servicecall *ServiceCall, session *Session) error {
+		for {
+			message, err := session.Receive()
+			switch {
+			case err == io.EOF:
+				return nil
+			case err == ErrSessionTimeout:
+				session.Send("timeout")
+				return nil
+			case err != nil:
+				return err
+			case message == "fail":
+				return errors.New("error: failed")
+			}
+			session.Send(strings.ToUpper(message))
+		}
+	})
+
+	// the client closes the session for sending, the service answers the
+	// messages and ends the session
+	session := open()
+	for _, message := range []string{"a", "b c", "{\"d\"}"} {
+		if err := session.Send(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := session.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Send("e"); err != ErrSessionClosed {
+		t.Errorf("Send() after CloseSend() = %v, want %v", err, ErrSessionClosed)
+	}
+	messages, err := receiveAll(session)
+	if err != io.EOF || !reflect.DeepEqual(messages, []string{"A", "B C", "{\"D\"}"}) {
+		t.Errorf("received %q, %v, want [A B C {\"D\"}], EOF", messages, err)
+	}
+
+	// the service ends the session with an error, while the client still
+	// sends messages which the handler doesn't read; they are discarded
+	// until the client closes the session
+	session = open()
+	session.Send("fail")
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 1000; i++ {
+		if err := session.Send("unread"); err != nil {
+			t.Fatalf("Send() = %v, the service reset the session", err)
+		}
+	}
+	messages, err = receiveAll(session)
+	serviceError, ok := err.(*ServiceError)
+	if !ok || serviceError.Code != SERVICE_FAILED {
+		t.Errorf("received %d messages, %v, want the error of the handler", len(messages), err)
+	}
+
+	// the handler stops waiting for messages after SESSION_IDLE_TIMEOUT
+	session = open()
+	messages, err = receiveAll(session)
+	if err != io.EOF || !reflect.DeepEqual(messages, []string{"timeout"}) {
+		t.Errorf("received %q, %v, want [timeout], EOF", messages, err)
+	}
+}
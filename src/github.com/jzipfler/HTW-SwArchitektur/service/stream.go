@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// Sends one result of a streaming call to the client. It blocks while the
+// client isn't ready for more results (backpressure) and returns an error
+// if the client canceled the call or the connection was lost; the handler
+// should stop then.
+type StreamSender func(result string) error
+
+// Handler of a streaming service (see RunStreamService()), which sends any
+// number of results. Returning an error ends the stream with that error.
+// The context is canceled when the client cancels the call.
+type StreamHandler func(ctx context.Context, servicecall *ServiceCall, send StreamSender) error
+
+var (
+	// Number of results the client buffers before the service has to wait.
+	STREAM_BUFFER = 16
+	// Maximum time the service waits for the client to accept a result.
+	STREAM_SEND_TIMEOUT = time.Minute
+)
+
+const (
+	// The handler of a streaming or interactive service returned an error.
+	SERVICE_FAILED = "failed"
+	// The service streams its results, so it has to be called with
+	// CallServiceStream().
+	SERVICE_STREAMING = "streaming"
+)
+
+// Registers and starts a streaming service, whose calls are handled by
+// the given handler (see CallServiceStream()). Sets serviceinfo.Streaming.
+// Note that this function blocks forever.
+func RunStreamService(serviceinfo *ServiceInfo, handler StreamHandler) error {
+	serviceinfo.Streaming = true
+	return runService(serviceinfo, &serviceRuntime{info: serviceinfo, stream: handler})
+}
+
+// Serves a streaming call: sends every result of the handler and a final
+// result with End set, which carries the error of the handler, if any. A
+// non-streaming service sends its single result. The client cancels the
+// call by closing the connection.
+func serveStream(connection *net.TCPConn, decoder *json.Decoder, runtime *serviceRuntime, servicecall ServiceCall) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		decoder.Decode(&json.RawMessage{})
+		cancel()
+	}()
+
+	encoder := json.NewEncoder(connection)
+	send := func(result string) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		connection.SetWriteDeadline(time.Now().Add(STREAM_SEND_TIMEOUT))
+		return encoder.Encode(ServiceResult{Result: result})
+	}
+
+	end := ServiceResult{}
+	if runtime.stream == nil {
+		result := runtime.call(&servicecall)
+		if result.Error == nil {
+			send(result.Result)
+		}
+		end.Error = result.Error
+	} else if release, err := runtime.admit(&servicecall); err != nil {
+		end.Error = err
+	} else {
+		end = runtime.invokeStream(ctx, &servicecall, send)
+		release()
+	}
+
+	end.End = true
+	connection.SetWriteDeadline(time.Now().Add(STREAM_SEND_TIMEOUT))
+	return encoder.Encode(end)
+}
+
+// Results of a streaming call, see CallServiceStream().
+type ResultStream struct {
+	results chan string
+	err     error
+	cancel  context.CancelFunc
+	// closed at the end of the stream, after err was set
+	done chan struct{}
+}
+
+// Invokes the highest version of the streaming service specified by name
+// which satisfies the version constraint with the given arguments. The
+// results are received in the background; the service waits while
+// STREAM_BUFFER results haven't been read yet. The call is canceled when
+// the context is done or the stream is closed. Streaming calls aren't
+// retried and not passed to client interceptors.
+func CallServiceStream(ctx context.Context, name, constraint string, args ...string) (*ResultStream, error) {
+	instance, err := GetServiceInfoVersion(name, constraint)
+	if err == nil && instance.Address == "" {
+		err = errors.New("error: service \"" + name + "\" not found!")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	dialer := net.Dialer{}
+	connection, err := dialer.DialContext(ctx, TCP_PROTOCOL, instance.Address)
+	if err != nil {
+		cancel()
+		InvalidateLookupCache(name)
+		return nil, err
+	}
+	bytes, err := json.Marshal(ServiceCall{Name: name, Arguments: args, Stream: true})
+	if err == nil {
+		_, err = connection.Write(bytes)
+	}
+	if err != nil {
+		cancel()
+		connection.Close()
+		return nil, err
+	}
+
+	stream := &ResultStream{results: make(chan string, STREAM_BUFFER), cancel: cancel, done: make(chan struct{})}
+	go stream.receive(ctx, connection)
+	return stream, nil
+}
+
+// Receives the results from the connection until the end of the stream.
+func (stream *ResultStream) receive(ctx context.Context, connection net.Conn) {
+	// done is closed before results, so that Err() returns the error of
+	// the stream once the results are drained
+	defer close(stream.results)
+	defer close(stream.done)
+	defer connection.Close()
+	defer stream.cancel()
+
+	// interrupt pending reads when the context is done
+	go func() {
+		<-ctx.Done()
+		connection.SetDeadline(time.Now())
+	}()
+
+	decoder := json.NewDecoder(connection)
+	for received := 0; ; received++ {
+		result := ServiceResult{}
+		err := decoder.Decode(&result)
+		switch {
+		case err == io.EOF && received == 1:
+			// services without streaming send a single result
+			return
+		case err == io.EOF:
+			stream.err = io.ErrUnexpectedEOF
+			return
+		case err != nil:
+			stream.err = contextError(ctx, err)
+			return
+		case result.Error != nil:
+			stream.err = result.Error
+			return
+		case result.End:
+			return
+		}
+
+		select {
+		case stream.results <- result.Result:
+		case <-ctx.Done():
+			stream.err = ctx.Err()
+			return
+		}
+	}
+}
+
+// Returns a channel which receives the results and is closed at the end of
+// the stream. Err() returns the error of the stream afterwards.
+func (stream *ResultStream) Results() <-chan string {
+	return stream.results
+}
+
+// Returns the next result. At the end of the stream, the error of the
+// stream or io.EOF is returned.
+func (stream *ResultStream) Next() (string, error) {
+	result, ok := <-stream.results
+	if !ok {
+		if stream.err != nil {
+			return "", stream.err
+		}
+		return "", io.EOF
+	}
+	return result, nil
+}
+
+// Returns the error which ended the stream, or nil if it ended regularly or
+// hasn't ended yet.
+func (stream *ResultStream) Err() error {
+	select {
+	case <-stream.done:
+		return stream.err
+	default:
+		return nil
+	}
+}
+
+// Cancels the call. Results which were already received can still be read.
+func (stream *ResultStream) Close() {
+	stream.cancel()
+}
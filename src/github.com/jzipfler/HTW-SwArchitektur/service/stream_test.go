@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestResultStream(t *testing.T) {
+	failed := &ServiceError{SERVICE_FAILED, "disk full"}
+	tests := []struct {
+		name    string
+		sent    []ServiceResult
+		results []string
+		err     error
+	}{
+		{"regular end", []ServiceResult{{Result: "2"}, {Result: "3"}, {End: true}}, []string{"2", "3"}, nil},
+		{"error", []ServiceResult{{Result: "2"}, {End: true, Error: failed}}, []string{"2"}, failed},
+		{"single result", []ServiceResult{{Result: "true"}}, []string{"true"}, nil},
+		{"connection lost", []ServiceResult{{Result: "2"}, {Result: "3"}}, []string{"2", "3"}, io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		client, server := net.Pipe()
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := &ResultStream{results: make(chan string, STREAM_BUFFER), cancel: cancel, done: make(chan struct{})}
+		go stream.receive(ctx, client)
+		go func(sent []ServiceResult) {
+			encoder := json.NewEncoder(server)
+			for _, result := range sent {
+				encoder.Encode(result)
+			}
+			server.Close()
+		}(test.sent)
+
+		results := []string{}
+		for result := range stream.Results() {
+			results = append(results, result)
+		}
+		// Err() must report the error as soon as the results are drained
+		if err := stream.Err(); !reflect.DeepEqual(results, test.results) || !reflect.DeepEqual(err, test.err) {
+			t.Errorf("%s: results %v, Err() = %v, want %v, %v", test.name, results, err, test.results, test.err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// The version constraint of a lookup is invalid.
+	SERVICE_INVALID_VERSION = "invalid_version"
+)
+
+// Semantic version of a service contract (MAJOR.MINOR.PATCH).
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// Returns the version in its "MAJOR.MINOR.PATCH" notation.
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compares two versions. The result is -1 if v < other, 0 if
+// v == other and 1 if v > other.
+func (v Version) Compare(other Version) int {
+	switch {
+	case v.Major != other.Major:
+		return compareInt(v.Major, other.Major)
+	case v.Minor != other.Minor:
+		return compareInt(v.Minor, other.Minor)
+	default:
+		return compareInt(v.Patch, other.Patch)
+	}
+}
+
+// Compares two integers, see Version.Compare().
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Parses a version like "1", "1.2" or "v1.2.3". Missing parts are 0.
+// An empty string is treated as version "0.0.0", so services which
+// don't declare a version are still accepted.
+func ParseVersion(s string) (Version, error) {
+	version, _, err := parseVersionParts(s)
+	return version, err
+}
+
+// Parses a version and additionally returns how many of its parts
+// were actually given (needed for constraints like "~1" or "1.2").
+func parseVersionParts(s string) (Version, int, error) {
+	version := Version{}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if s == "" {
+		return version, 0, nil
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return version, 0, errors.New("error: invalid version \"" + s + "\"")
+	}
+	numbers := []*int{&version.Major, &version.Minor, &version.Patch}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return version, 0, errors.New("error: invalid version \"" + s + "\"")
+		}
+		*numbers[i] = number
+	}
+
+	return version, len(parts), nil
+}
+
+// Checks whether the given version satisfies the constraint. Supported
+// constraints are (several constraints separated by spaces must all match):
+// * "" or "*"  (any version)
+// * "^1.2"     (>= 1.2.0 and < 2.0.0, for major version 0: < 0.3.0)
+// * "~1.2"     (>= 1.2.0 and < 1.3.0)
+// * "1.2"      (any 1.2.x version, "1.2.3" or "=1.2.3" is an exact match)
+// * ">=1.2", ">1.2", "<=1.2", "<1.2"
+func MatchVersion(constraint, version string) (bool, error) {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false, err
+	}
+
+	for _, c := range strings.Fields(constraint) {
+		ok, err := matchSingleConstraint(c, v)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Checks a single constraint of MatchVersion().
+func matchSingleConstraint(constraint string, v Version) (bool, error) {
+	if constraint == "*" {
+		return true, nil
+	}
+
+	operator := ""
+	for _, op := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(constraint, op) {
+			operator = op
+			break
+		}
+	}
+	bound, parts, err := parseVersionParts(constraint[len(operator):])
+	if err != nil {
+		return false, err
+	}
+	if parts == 0 {
+		return false, errors.New("error: invalid version constraint \"" + constraint + "\"")
+	}
+
+	switch operator {
+	case ">=":
+		return v.Compare(bound) >= 0, nil
+	case "<=":
+		return v.Compare(bound) <= 0, nil
+	case ">":
+		return v.Compare(bound) > 0, nil
+	case "<":
+		return v.Compare(bound) < 0, nil
+	case "^":
+		upper := Version{bound.Major + 1, 0, 0}
+		if bound.Major == 0 && parts > 1 {
+			upper = Version{0, bound.Minor + 1, 0}
+		}
+		return v.Compare(bound) >= 0 && v.Compare(upper) < 0, nil
+	case "~":
+		upper := Version{bound.Major, bound.Minor + 1, 0}
+		if parts == 1 {
+			upper = Version{bound.Major + 1, 0, 0}
+		}
+		return v.Compare(bound) >= 0 && v.Compare(upper) < 0, nil
+	}
+
+	// exact match on the given parts ("1.2" matches any 1.2.x)
+	match := v.Major == bound.Major
+	if parts > 1 {
+		match = match && v.Minor == bound.Minor
+	}
+	if parts > 2 {
+		match = match && v.Patch == bound.Patch
+	}
+	return match, nil
+}
+
+// Checks whether the version constraint is valid, see MatchVersion().
+func ValidateConstraint(constraint string) error {
+	_, err := MatchVersion(constraint, "")
+	return err
+}
+
+// Selects the instance with the highest version that satisfies the
+// constraint from the given instances (the last one of equal versions,
+// which was registered latest). Returns false if none matches and
+// an error if the constraint is invalid.
+func selectInstance(instances []ServiceInfoAddress, constraint string) (ServiceInfoAddress, bool, error) {
+	var best ServiceInfoAddress
+	var bestVersion Version
+	found := false
+
+	err := ValidateConstraint(constraint)
+	if err != nil {
+		return best, false, err
+	}
+	for _, instance := range instances {
+		ok, err := MatchVersion(constraint, instance.Info.Version)
+		if err != nil || !ok {
+			continue
+		}
+		version, _ := ParseVersion(instance.Info.Version)
+		// of equal versions, the latest registration wins
+		if !found || version.Compare(bestVersion) >= 0 {
+			best, bestVersion, found = instance, version, true
+		}
+	}
+
+	return best, found, nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		input   string
+		version Version
+		valid   bool
+	}{
+		{"", Version{0, 0, 0}, true},
+		{"1", Version{1, 0, 0}, true},
+		{"1.2", Version{1, 2, 0}, true},
+		{"1.2.3", Version{1, 2, 3}, true},
+		{"v1.2.3", Version{1, 2, 3}, true},
+		{" 2.0 ", Version{2, 0, 0}, true},
+		{"1.2.3.4", Version{}, false},
+		{"1.x", Version{}, false},
+		{"-1", Version{}, false},
+		{"1..2", Version{}, false},
+	}
+
+	for _, test := range tests {
+		version, err := ParseVersion(test.input)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseVersion(%q): error %v, valid %v", test.input, err, test.valid)
+			continue
+		}
+		if test.valid && version != test.version {
+			t.Errorf("ParseVersion(%q) = %v, want %v", test.input, version, test.version)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b   Version
+		result int
+	}{
+		{Version{1, 2, 3}, Version{1, 2, 3}, 0},
+		{Version{1, 2, 3}, Version{1, 2, 4}, -1},
+		{Version{1, 3, 0}, Version{1, 2, 9}, 1},
+		{Version{2, 0, 0}, Version{10, 0, 0}, -1},
+	}
+
+	for _, test := range tests {
+		if result := test.a.Compare(test.b); result != test.result {
+			t.Errorf("%v.Compare(%v) = %d, want %d", test.a, test.b, result, test.result)
+		}
+	}
+}
+
+func TestMatchVersion(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		match      bool
+	}{
+		{"", "1.2.3", true},
+		{"*", "0.0.1", true},
+		{"^1.2", "1.2.0", true},
+		{"^1.2", "1.9.9", true},
+		{"^1.2", "1.1.9", false},
+		{"^1.2", "2.0.0", false},
+		{"^0.2", "0.2.5", true},
+		{"^0.2", "0.3.0", false},
+		{"^0", "0.9.0", true},
+		{"~1.2", "1.2.9", true},
+		{"~1.2", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{"~1", "2.0.0", false},
+		{"1.2", "1.2.7", true},
+		{"1.2", "1.3.0", false},
+		{"1.2.3", "1.2.3", true},
+		{"=1.2.3", "1.2.4", false},
+		{">=1.2", "1.2.0", true},
+		{">1.2", "1.2.0", false},
+		{"<=1.2", "1.2.0", true},
+		{"<1.2", "1.1.9", true},
+		{">=1.2 <2", "1.5.0", true},
+		{">=1.2 <2", "2.0.0", false},
+		{"1", "", false},
+		{"0", "", true},
+	}
+
+	for _, test := range tests {
+		match, err := MatchVersion(test.constraint, test.version)
+		if err != nil {
+			t.Errorf("MatchVersion(%q, %q): %v", test.constraint, test.version, err)
+		} else if match != test.match {
+			t.Errorf("MatchVersion(%q, %q) = %v, want %v", test.constraint, test.version, match, test.match)
+		}
+	}
+}
+
+func TestMatchVersionInvalid(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+	}{
+		{"^x", "1.0.0"},
+		{">=", "1.0.0"},
+		{"1.2.3.4", "1.0.0"},
+		{"^1", "1.x"},
+	}
+
+	for _, test := range tests {
+		if _, err := MatchVersion(test.constraint, test.version); err == nil {
+			t.Errorf("MatchVersion(%q, %q): no error", test.constraint, test.version)
+		}
+	}
+}
+
+func TestSelectInstance(t *testing.T) {
+	instances := []ServiceInfoAddress{
+		{Address: "a", Info: ServiceInfo{Version: "1.0.0"}},
+		{Address: "b", Info: ServiceInfo{Version: "1.4.0"}},
+		{Address: "c", Info: ServiceInfo{Version: "2.1.0"}},
+		{Address: "d", Info: ServiceInfo{Version: "invalid"}},
+	}
+	tests := []struct {
+		constraint string
+		address    string
+		found      bool
+	}{
+		{"", "c", true},
+		{"^1", "b", true},
+		{"~1.0", "a", true},
+		{">=3", "", false},
+	}
+
+	for _, test := range tests {
+		instance, found, err := selectInstance(instances, test.constraint)
+		if err != nil || found != test.found || instance.Address != test.address {
+			t.Errorf("selectInstance(%q) = %q, %v, %v, want %q, %v", test.constraint, instance.Address, found, err, test.address, test.found)
+		}
+	}
+
+	restarted := append(instances, ServiceInfoAddress{Address: "e", Info: ServiceInfo{Version: "2.1.0"}})
+	if instance, _, _ := selectInstance(restarted, ""); instance.Address != "e" {
+		t.Errorf("selectInstance() of equal versions = %q, want the latest registration", instance.Address)
+	}
+
+	if _, found, err := selectInstance(instances, "^a.b"); found || err == nil {
+		t.Errorf("selectInstance(\"^a.b\") = %v, %v, want an error", found, err)
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+)
+
+var (
+	// Operation for LookupInfoRequest: keep the connection open and send a
+	// RegistryEvent for every change of the registered services.
+	OPERATION_WATCH = "watch"
+	// Number of events buffered per watcher. Watchers which don't keep up
+	// are disconnected.
+	WATCH_BUFFER = 64
+	// Channels of all watchers. Guarded by servicesLock.
+	watchers = make(map[chan RegistryEvent]bool)
+)
+
+// Types of RegistryEvent.
+const (
+	EVENT_REGISTERED   = "registered"
+	EVENT_DEREGISTERED = "deregistered"
+	EVENT_DRAINED      = "drained"
+	EVENT_UNDRAINED    = "undrained"
+	EVENT_HEALTHY      = "healthy"
+	EVENT_UNHEALTHY    = "unhealthy"
+	// The services of a peer registry changed, Name and Address are empty.
+	EVENT_PEERS = "peers"
+)
+
+// Change of the registered services, sent to watchers of the registry
+// (see OPERATION_WATCH).
+type RegistryEvent struct {
+	Type    string
+	Name    string
+	Address string
+}
+
+// Sends an event to all watchers. The caller must hold servicesLock.
+func publishEvent(event RegistryEvent) {
+	for watcher := range watchers {
+		select {
+		case watcher <- event:
+		default:
+			// the watcher missed an event, so it must start over
+			delete(watchers, watcher)
+			close(watcher)
+		}
+	}
+}
+
+// Publishes EVENT_PEERS if the catalog of a peer registry changed. The
+// caller must hold servicesLock.
+func publishCatalogChange(older, newer map[string][]ServiceInfoAddress) {
+	if !reflect.DeepEqual(older, newer) {
+		publishEvent(RegistryEvent{Type: EVENT_PEERS})
+	}
+}
+
+// Sends the events of the registry to a watcher until the connection is
+// closed.
+func serveWatch(connection *net.TCPConn) error {
+	events := make(chan RegistryEvent, WATCH_BUFFER)
+	servicesLock.Lock()
+	watchers[events] = true
+	servicesLock.Unlock()
+
+	defer func() {
+		servicesLock.Lock()
+		if watchers[events] {
+			delete(watchers, events)
+			close(events)
+		}
+		servicesLock.Unlock()
+	}()
+
+	// the watcher doesn't send anything, reading only detects the close
+	closed := make(chan struct{})
+	go func() {
+		buffer := make([]byte, 1)
+		for {
+			if _, err := connection.Read(buffer); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	encoder := json.NewEncoder(connection)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			err := encoder.Encode(event)
+			if err != nil {
+				return err
+			}
+		case <-closed:
+			return nil
+		}
+	}
+}
+
+// Watches the registry for changes of the registered services. The
+// returned channel receives a RegistryEvent for every change and is closed
+// when the connection to the registry is lost (events may be missed then).
+func WatchRegistry() (<-chan RegistryEvent, error) {
+	address, err := GetRegistryAddress()
+	if err != nil {
+		return nil, err
+	}
+	connection, err := net.DialTCP(TCP_PROTOCOL, nil, address)
+	if err != nil {
+		resetRegistryAddress()
+		return nil, err
+	}
+
+	bytes, err := json.Marshal(LookupInfoRequest{Operation: OPERATION_WATCH})
+	if err == nil {
+		_, err = connection.Write(bytes)
+	}
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	events := make(chan RegistryEvent)
+	go func() {
+		defer connection.Close()
+		defer close(events)
+
+		decoder := json.NewDecoder(connection)
+		for {
+			event := RegistryEvent{}
+			if decoder.Decode(&event) != nil {
+				return
+			}
+			events <- event
+		}
+	}()
+	return events, nil
+}
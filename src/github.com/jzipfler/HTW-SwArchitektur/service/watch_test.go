@@ -0,0 +1,109 @@
+package service
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPublishEvent(t *testing.T) {
+	servicesLock.Lock()
+	defer servicesLock.Unlock()
+	defer func() { watchers = make(map[chan RegistryEvent]bool) }()
+
+	fast := make(chan RegistryEvent, 2)
+	slow := make(chan RegistryEvent, 1)
+	watchers = map[chan RegistryEvent]bool{fast: true, slow: true}
+	publishEvent(RegistryEvent{EVENT_REGISTERED, "isprime", "10.0.0.2:4000"})
+	publishEvent(RegistryEvent{EVENT_DEREGISTERED, "isprime", "10.0.0.2:4000"})
+
+	if len(fast) != 2 || !watchers[fast] {
+		t.Errorf("watcher received %d events, want 2", len(fast))
+	}
+	if watchers[slow] {
+		t.Error("watcher which missed an event is still registered")
+	}
+	<-slow
+	if _, ok := <-slow; ok {
+		t.Error("channel of the watcher which missed an event wasn't closed")
+	}
+
+	<-fast
+	<-fast
+	publishCatalogChange(nil, nil)
+	publishCatalogChange(map[string][]ServiceInfoAddress{"isprime": {{Address: "10.0.0.2:4000"}}}, nil)
+	if len(fast) != 1 || (<-fast).Type != EVENT_PEERS {
+		t.Error("catalog changes weren't published as a single EVENT_PEERS")
+	}
+}
+
+func TestWatchRegistry(t *testing.T) {
+	listener, err := net.ListenTCP(TCP_PROTOCOL, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		connection, err := listener.AcceptTCP()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+		json.NewDecoder(connection).Decode(&LookupInfoRequest{})
+		serveWatch(connection)
+	}()
+
+	registryAddressLock.Lock()
+	registryAddress = listener.Addr().(*net.TCPAddr)
+	registryAddressLock.Unlock()
+	defer resetRegistryAddress()
+
+	events, err := WatchRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for registered := false; !registered; time.Sleep(10 * time.Millisecond) {
+		servicesLock.Lock()
+		registered = len(watchers) == 1
+		servicesLock.Unlock()
+	}
+
+	// the cache invalidates the lookups of the service of an event
+	defer func(ttl time.Duration, watch bool) { LOOKUP_CACHE_TTL, LOOKUP_CACHE_WATCH = ttl, watch }(LOOKUP_CACHE_TTL, LOOKUP_CACHE_WATCH)
+	LOOKUP_CACHE_TTL, LOOKUP_CACHE_WATCH = time.Minute, false
+	cache := lookupCacheMap{entries: make(map[LookupInfoRequest]cachedLookup)}
+	cache.put(OPERATION_ADDRESS, "isprime", "", "10.0.0.2:4000")
+
+	servicesLock.Lock()
+	publishEvent(RegistryEvent{EVENT_UNHEALTHY, "isprime", "10.0.0.2:4000"})
+	servicesLock.Unlock()
+	select {
+	case event := <-events:
+		if event != (RegistryEvent{EVENT_UNHEALTHY, "isprime", "10.0.0.2:4000"}) {
+			t.Errorf("received %v", event)
+		}
+		cache.invalidate(event.Name)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+	if _, ok := cache.get(OPERATION_ADDRESS, "isprime", ""); ok {
+		t.Error("lookup of the changed service is still cached")
+	}
+
+	// the watch ends when the connection is lost
+	servicesLock.Lock()
+	for watcher := range watchers {
+		delete(watchers, watcher)
+		close(watcher)
+	}
+	servicesLock.Unlock()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("received an event after the connection was lost")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("channel wasn't closed after the connection was lost")
+	}
+}
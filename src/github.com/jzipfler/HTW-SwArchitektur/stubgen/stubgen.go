@@ -0,0 +1,373 @@
+// Command stubgen generates typed Go client functions and server-side
+// handler interfaces from service contracts. Each contract is either a
+// JSON or YAML contract file (see service.LoadContract()) or the name of a
+// registered service with an optional version constraint. Without contracts, stubs
+// for all registered services are generated. It's meant to be used with
+// go generate, e.g.
+//
+//	//go:generate stubgen -package main -out isprime_stub.go -names isprime=IsPrime isprime.json
+//
+// For the "isprime" contract this generates
+//
+//	func IsPrime(ctx context.Context, x int64) (string, error)
+//	type IsPrimeHandler interface { IsPrime(x int64) (string, error) }
+//	func IsPrimeService(handler IsPrimeHandler) service.ServiceHandler
+//	var IsPrimeContract service.ServiceInfo
+//
+// so that a service can be run with
+// service.RunService(&IsPrimeContract, IsPrimeService(handler)). For
+// streaming services, the client function returns a *service.ResultStream,
+// the handler sends its results with a service.StreamSender, and the
+// service is run with service.RunStreamService(). Likewise, interactive
+// services get a *service.Session and are run with
+// service.RunSessionService().
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/jzipfler/HTW-SwArchitektur/service"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Go type of a contract argument type and the expressions to convert
+// between the type and its string representation ("%s" is the value).
+type goType struct {
+	Name   string
+	Format string
+	Parse  string
+}
+
+// Go types of the contract argument types. Unknown types are passed as
+// string.
+var goTypes = map[string]goType{
+	"int":     {"int64", "strconv.FormatInt(%s, 10)", "strconv.ParseInt(%s, 10, 64)"},
+	"integer": {"int64", "strconv.FormatInt(%s, 10)", "strconv.ParseInt(%s, 10, 64)"},
+	"long":    {"int64", "strconv.FormatInt(%s, 10)", "strconv.ParseInt(%s, 10, 64)"},
+	"float":   {"float64", "strconv.FormatFloat(%s, 'g', -1, 64)", "strconv.ParseFloat(%s, 64)"},
+	"double":  {"float64", "strconv.FormatFloat(%s, 'g', -1, 64)", "strconv.ParseFloat(%s, 64)"},
+	"bool":    {"bool", "strconv.FormatBool(%s)", "strconv.ParseBool(%s)"},
+	"boolean": {"bool", "strconv.FormatBool(%s)", "strconv.ParseBool(%s)"},
+}
+
+// Names used in the generated code, which arguments must not shadow.
+var reservedNames = map[string]bool{
+	"ctx": true, "err": true, "result": true, "handler": true,
+	"servicecall": true, "service": true, "strconv": true, "context": true,
+	"send": true, "session": true,
+}
+
+// An argument of a generated function.
+type stubArgument struct {
+	service.ArgumentInfo
+	Param  string
+	Type   string
+	Format string
+	Parse  string
+}
+
+// A service for which stubs are generated.
+type stubService struct {
+	Info       service.ServiceInfo
+	Ident      string
+	Constraint string
+	Contract   string
+	Arguments  []stubArgument
+}
+
+// Template of the generated file.
+var stubTemplate = template.Must(template.New("stub").Parse(`// Code generated by stubgen; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+{{- if .Errors}}
+	"errors"
+{{- end}}
+	"github.com/jzipfler/HTW-SwArchitektur/service"
+{{- if .Strconv}}
+	"strconv"
+{{- end}}
+)
+{{range .Services}}
+// Contract of the "{{.Info.Name}}" service.
+var {{.Ident}}Contract = {{.Contract}}
+
+// Invokes the "{{.Info.Name}}" service ({{.Constraint}}): {{.Info.Description}}
+{{- range .Arguments}}
+//	{{.Param}}: {{.Description}}
+{{- end}}
+{{- if .Info.Interactive}}
+func {{.Ident}}(ctx context.Context{{range .Arguments}}, {{.Param}} {{.Type}}{{end}}) (*service.Session, error) {
+	return service.OpenSession(ctx, {{printf "%q" .Info.Name}}, {{printf "%q" .Constraint}}{{range .Arguments}}, {{.Format}}{{end}})
+}
+
+// Implementation of the "{{.Info.Name}}" interactive service.
+type {{.Ident}}Handler interface {
+	{{.Ident}}(ctx context.Context{{range .Arguments}}, {{.Param}} {{.Type}}{{end}}, session *service.Session) error
+}
+
+// Returns a service.SessionHandler for {{.Ident}}Contract, which converts
+// the arguments and invokes the handler.
+func {{.Ident}}Service(handler {{.Ident}}Handler) service.SessionHandler {
+	return func(ctx context.Context, servicecall *service.ServiceCall, session *service.Session) error {
+{{- template "arguments" .}}
+		return handler.{{.Ident}}(ctx{{range .Arguments}}, {{.Param}}{{end}}, session)
+	}
+}
+{{- else if .Info.Streaming}}
+func {{.Ident}}(ctx context.Context{{range .Arguments}}, {{.Param}} {{.Type}}{{end}}) (*service.ResultStream, error) {
+	return service.CallServiceStream(ctx, {{printf "%q" .Info.Name}}, {{printf "%q" .Constraint}}{{range .Arguments}}, {{.Format}}{{end}})
+}
+
+// Implementation of the "{{.Info.Name}}" streaming service.
+type {{.Ident}}Handler interface {
+	{{.Ident}}(ctx context.Context{{range .Arguments}}, {{.Param}} {{.Type}}{{end}}, send service.StreamSender) error
+}
+
+// Returns a service.StreamHandler for {{.Ident}}Contract, which converts
+// the arguments and invokes the handler.
+func {{.Ident}}Service(handler {{.Ident}}Handler) service.StreamHandler {
+	return func(ctx context.Context, servicecall *service.ServiceCall, send service.StreamSender) error {
+{{- template "arguments" .}}
+		return handler.{{.Ident}}(ctx{{range .Arguments}}, {{.Param}}{{end}}, send)
+	}
+}
+{{- else}}
+func {{.Ident}}(ctx context.Context{{range .Arguments}}, {{.Param}} {{.Type}}{{end}}) (string, error) {
+	return service.CallServiceContext(ctx, {{printf "%q" .Info.Name}}, {{printf "%q" .Constraint}}{{range .Arguments}}, {{.Format}}{{end}})
+}
+
+// Implementation of the "{{.Info.Name}}" service.
+type {{.Ident}}Handler interface {
+	{{.Ident}}({{range $i, $a := .Arguments}}{{if $i}}, {{end}}{{$a.Param}} {{$a.Type}}{{end}}) (string, error)
+}
+
+// Returns a service.ServiceHandler for {{.Ident}}Contract, which converts
+// the arguments and invokes the handler. Errors are returned as result.
+func {{.Ident}}Service(handler {{.Ident}}Handler) service.ServiceHandler {
+	return func(servicecall *service.ServiceCall) string {
+{{- if .Arguments}}
+		if len(servicecall.Arguments) != {{len .Arguments}} {
+			return "error: expected {{len .Arguments}} arguments"
+		}
+{{- end}}
+{{- range $i, $a := .Arguments}}
+{{- if $a.Parse}}
+		{{$a.Param}}, err := {{$a.Parse}}
+		if err != nil {
+			return "error: invalid argument {{$a.Name}}: " + err.Error()
+		}
+{{- else}}
+		{{$a.Param}} := servicecall.Arguments[{{$i}}]
+{{- end}}
+{{- end}}
+		result, err := handler.{{.Ident}}({{range $i, $a := .Arguments}}{{if $i}}, {{end}}{{$a.Param}}{{end}})
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return result
+	}
+}
+{{- end}}
+{{end}}
+{{- define "arguments"}}
+{{- if .Arguments}}
+		if len(servicecall.Arguments) != {{len .Arguments}} {
+			return errors.New("expected {{len .Arguments}} arguments")
+		}
+{{- end}}
+{{- range $i, $a := .Arguments}}
+{{- if $a.Parse}}
+		{{$a.Param}}, err := {{$a.Parse}}
+		if err != nil {
+			return errors.New("invalid argument {{$a.Name}}: " + err.Error())
+		}
+{{- else}}
+		{{$a.Param}} := servicecall.Arguments[{{$i}}]
+{{- end}}
+{{- end}}
+{{- end}}`))
+
+// Loads the given contracts, or the contracts of all registered services.
+func loadContracts(sources []string) ([]service.ServiceInfo, error) {
+	contracts := []service.ServiceInfo{}
+	if len(sources) == 0 {
+		list, err := service.GetServiceList()
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range *list {
+			contracts = append(contracts, instance.Info)
+		}
+		sort.Slice(contracts, func(i, j int) bool { return contracts[i].Name < contracts[j].Name })
+		return contracts, nil
+	}
+
+	for _, source := range sources {
+		info, err := service.LoadContractSource(source)
+		if err != nil {
+			return nil, err
+		}
+		contracts = append(contracts, *info)
+	}
+	return contracts, nil
+}
+
+// Converts a name like "team-a/is_prime" to an identifier ("TeamAIsPrime"),
+// optionally starting with a lower case letter.
+func identifier(name string, exported bool) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	ident := ""
+	for _, part := range parts {
+		ident += strings.ToUpper(part[:1]) + part[1:]
+	}
+	if ident == "" || unicode.IsDigit(rune(ident[0])) {
+		ident = "X" + ident
+	}
+	if !exported {
+		ident = strings.ToLower(ident[:1]) + ident[1:]
+	}
+	return ident
+}
+
+// Returns the contract as Go composite literal.
+func contractLiteral(info service.ServiceInfo) string {
+	literal := fmt.Sprintf("service.ServiceInfo{\nName: %q,\nVersion: %q,\nResultType: %q,\nDescription: %q,\nArguments: []service.ArgumentInfo{\n",
+		info.Name, info.Version, info.ResultType, info.Description)
+	for _, argument := range info.Arguments {
+		literal += fmt.Sprintf("{Name: %q, Type: %q, Description: %q},\n", argument.Name, argument.Type, argument.Description)
+	}
+	literal += "},\n"
+	if info.Idempotent {
+		literal += "Idempotent: true,\n"
+	}
+	if info.Streaming {
+		literal += "Streaming: true,\n"
+	}
+	if info.Interactive {
+		literal += "Interactive: true,\n"
+	}
+	return literal + "}"
+}
+
+// Returns the stub data of a contract.
+func stubFor(info service.ServiceInfo, ident string) stubService {
+	stub := stubService{Info: info, Ident: ident, Contract: contractLiteral(info)}
+	if version, err := service.ParseVersion(info.Version); err == nil {
+		stub.Constraint = fmt.Sprintf("^%d", version.Major)
+		if version.Major == 0 {
+			stub.Constraint = "^" + version.String()
+		}
+	}
+
+	used := make(map[string]bool)
+	for i, argument := range info.Arguments {
+		if argument.Type == "void" {
+			continue
+		}
+		param := identifier(argument.Name, false)
+		if token.IsKeyword(param) || reservedNames[param] {
+			param += "Arg"
+		}
+		if used[param] {
+			param = fmt.Sprintf("%s%d", param, i)
+		}
+		used[param] = true
+
+		stubArgument := stubArgument{ArgumentInfo: argument, Param: param, Type: "string", Format: param}
+		if conversion, ok := goTypes[strings.ToLower(argument.Type)]; ok {
+			stubArgument.Type = conversion.Name
+			stubArgument.Format = fmt.Sprintf(conversion.Format, param)
+			stubArgument.Parse = fmt.Sprintf(conversion.Parse, fmt.Sprintf("servicecall.Arguments[%d]", len(stub.Arguments)))
+		}
+		stub.Arguments = append(stub.Arguments, stubArgument)
+	}
+	return stub
+}
+
+// Parses the -names flag ("isprime=IsPrime,random=Random").
+func parseNames(value string) (map[string]string, error) {
+	names := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || !token.IsIdentifier(parts[1]) || !token.IsExported(parts[1]) {
+			return nil, fmt.Errorf("error: invalid name %q", entry)
+		}
+		names[parts[0]] = parts[1]
+	}
+	return names, nil
+}
+
+func main() {
+	pkg := flag.String("package", "main", "package of the generated file")
+	out := flag.String("out", "", "output file (default stdout)")
+	nameFlag := flag.String("names", "", "identifiers of services, e.g. isprime=IsPrime,random=Random")
+	flag.Parse()
+
+	names, err := parseNames(*nameFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	contracts, err := loadContracts(flag.Args())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	data := struct {
+		Package  string
+		Strconv  bool
+		Errors   bool
+		Services []stubService
+	}{Package: *pkg}
+	for _, info := range contracts {
+		ident, ok := names[info.Name]
+		if !ok {
+			ident = identifier(info.Name, true)
+		}
+		stub := stubFor(info, ident)
+		data.Errors = data.Errors || ((info.Streaming || info.Interactive) && len(stub.Arguments) > 0)
+		for _, argument := range stub.Arguments {
+			data.Strconv = data.Strconv || argument.Parse != ""
+		}
+		data.Services = append(data.Services, stub)
+	}
+
+	buffer := bytes.Buffer{}
+	err = stubTemplate.Execute(&buffer, data)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	source, err := format.Source(buffer.Bytes())
+	if err != nil {
+		fmt.Println("error: invalid generated code:", err)
+		os.Exit(1)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(source)
+		return
+	}
+	err = ioutil.WriteFile(*out, source, 0644)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}